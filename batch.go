@@ -0,0 +1,128 @@
+package pgfs
+
+import (
+	"context"
+	"io"
+)
+
+// Batch accumulates operations on files, to be applied
+// together with [Batch.Apply]. It's returned by [FS.Batch].
+type Batch struct {
+	fsys *FS
+	ops  []batchOp
+}
+
+// batchOp is an operation of a [Batch].
+type batchOp struct {
+	op   string
+	name string
+	fn   func(fsys *FS) error
+}
+
+// BatchResult is the outcome of an operation
+// applied by [Batch.Apply].
+type BatchResult struct {
+	Op   string // "create", "remove", "setsys" or "mergesys"
+	Name string
+	Err  error
+}
+
+// Batch returns an empty [Batch] of operations on fsys.
+func (fsys *FS) Batch() *Batch {
+	return &Batch{fsys: fsys}
+}
+
+func (b *Batch) add(op, name string, fn func(fsys *FS) error) *Batch {
+	b.ops = append(b.ops, batchOp{op: op, name: name, fn: fn})
+	return b
+}
+
+// Create adds the creation of a file with the content of r.
+// See [FS.Create] for more details on the other arguments.
+func (b *Batch) Create(name, contentType string, sys Sys, r io.Reader) *Batch {
+	return b.add("create", name, func(fsys *FS) error {
+		wc, err := fsys.Create(name, contentType, sys)
+		if err != nil {
+			return err
+		}
+		w := wc.(*writer)
+		if _, err := io.Copy(w, r); err != nil {
+			w.abort()
+			return err
+		}
+		return w.Close()
+	})
+}
+
+// Remove adds the removal of a file. See [FS.Remove].
+func (b *Batch) Remove(name string) *Batch {
+	return b.add("remove", name, func(fsys *FS) error {
+		return fsys.Remove(name)
+	})
+}
+
+// SetSys adds the replacement of the [Sys] of a file.
+// See [FS.SetSys].
+func (b *Batch) SetSys(name string, sys Sys) *Batch {
+	return b.add("setsys", name, func(fsys *FS) error {
+		return fsys.SetSys(name, sys)
+	})
+}
+
+// MergeSys adds the merge of patch into the [Sys] of a file.
+// See [FS.MergeSys].
+func (b *Batch) MergeSys(name string, patch Sys) *Batch {
+	return b.add("mergesys", name, func(fsys *FS) error {
+		return fsys.MergeSys(name, patch)
+	})
+}
+
+// Apply runs the operations of b in order, and stops at the first
+// one that fails, in which case the effects of all the operations
+// are rolled back and its error is returned.
+//
+// The results hold the outcome of each operation that was run,
+// so the one that failed is last.
+//
+// Operations are wrapped in a savepoint of the transaction of the
+// [FS], or in a transaction of their own if it was returned by
+// [NewDB]. The transaction is left usable whatever the outcome.
+func (b *Batch) Apply() ([]BatchResult, error) {
+	fsys := b.fsys
+	if fsys.db != nil {
+		c, tx, err := fsys.begin(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		results, err := b.run(c)
+		return results, end(tx, err)
+	}
+
+	if _, err := fsys.conn.Exec(`SAVEPOINT pgfs_batch`); err != nil {
+		return nil, err
+	}
+	results, err := b.run(fsys)
+	if err != nil {
+		if _, rErr := fsys.conn.Exec(`ROLLBACK TO SAVEPOINT pgfs_batch`); rErr != nil {
+			return results, rErr
+		}
+		return results, err
+	}
+	if _, err := fsys.conn.Exec(`RELEASE SAVEPOINT pgfs_batch`); err != nil {
+		return results, err
+	}
+	return results, nil
+}
+
+// run runs the operations of b on fsys.
+func (b *Batch) run(fsys *FS) ([]BatchResult, error) {
+	results := make([]BatchResult, 0, len(b.ops))
+	for _, op := range b.ops {
+		err := op.fn(fsys)
+		results = append(results, BatchResult{Op: op.op, Name: op.name, Err: err})
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
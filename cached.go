@@ -0,0 +1,236 @@
+package pgfs
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"io"
+	"io/fs"
+
+	"github.com/google/uuid"
+)
+
+// defaultBlockSize is the size of the blocks
+// cached by [FS.OpenCached].
+const defaultBlockSize = 32 << 10
+
+// OpenCached opens the file with the given name, and returns a
+// reader that keeps up to maxCacheBytes of its content in memory,
+// so repeated seeks and reads over the same ranges don't go back
+// to the database.
+//
+// Content is fetched lazily in blocks, and the oldest blocks are
+// evicted first when the cache is full.
+func (fsys *FS) OpenCached(name string, maxCacheBytes int64) (io.ReadSeekCloser, error) {
+	ff, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := ff.(*file)
+	if !ok {
+		ff.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	blockSize := int64(defaultBlockSize)
+	if maxCacheBytes > 0 && maxCacheBytes < blockSize {
+		blockSize = maxCacheBytes
+	}
+	c := &cachedFile{
+		f:         f,
+		size:      f.info.contentSize,
+		blockSize: blockSize,
+		max:       maxCacheBytes,
+		blocks:    make(map[int64][]byte),
+	}
+	return c, nil
+}
+
+// cachedFile implements [io.ReadSeekCloser] on top of
+// a [file] and an in-memory cache of blocks.
+type cachedFile struct {
+	f         *file
+	size      int64
+	pos       int64
+	blockSize int64
+	max       int64
+	cached    int64
+	blocks    map[int64][]byte
+	order     []int64 // blocks by insertion order
+}
+
+// block returns the content of the i-th block,
+// fetching it if it's not cached.
+func (c *cachedFile) block(i int64) ([]byte, error) {
+	if b, ok := c.blocks[i]; ok {
+		return b, nil
+	}
+
+	off := i * c.blockSize
+	n := c.blockSize
+	if off+n > c.size {
+		n = c.size - off
+	}
+	if _, err := c.f.Seek(off, io.SeekStart); err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(c.f, b); err != nil {
+		return nil, err
+	}
+
+	if n > c.max {
+		return b, nil
+	}
+	for c.cached+n > c.max && len(c.order) > 0 {
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		c.cached -= int64(len(c.blocks[oldest]))
+		delete(c.blocks, oldest)
+	}
+	c.blocks[i] = b
+	c.order = append(c.order, i)
+	c.cached += n
+	return b, nil
+}
+
+// Read implements [io.Reader].
+func (c *cachedFile) Read(p []byte) (int, error) {
+	if c.f.closed {
+		return 0, fs.ErrClosed
+	}
+	if c.pos >= c.size {
+		return 0, io.EOF
+	}
+
+	var total int
+	for total < len(p) && c.pos < c.size {
+		b, err := c.block(c.pos / c.blockSize)
+		if err != nil {
+			return total, err
+		}
+		n := copy(p[total:], b[c.pos%c.blockSize:])
+		total += n
+		c.pos += int64(n)
+	}
+	return total, nil
+}
+
+// Seek implements [io.Seeker]. It never queries
+// the database.
+func (c *cachedFile) Seek(offset int64, whence int) (int64, error) {
+	if c.f.closed {
+		return 0, fs.ErrClosed
+	}
+
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = c.pos + offset
+	case io.SeekEnd:
+		pos = c.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if pos < 0 {
+		return 0, errors.New("negative position")
+	}
+	c.pos = pos
+	return pos, nil
+}
+
+// Close implements [io.Closer].
+func (c *cachedFile) Close() error {
+	c.blocks = nil
+	c.order = nil
+	return c.f.Close()
+}
+
+var _ io.ReadSeekCloser = &cachedFile{}
+
+// DefaultOpenFullLimit is the size, in bytes, of the largest file
+// accepted by [FS.OpenFull], unless changed with [FS.OpenFullLimit].
+const DefaultOpenFullLimit = 64 << 20
+
+// OpenFull loads the whole content of the file with the given
+// name in memory with lo_get, and returns a reader over it, so
+// reads and seeks don't go back to the database. No descriptor
+// is kept open.
+//
+// An error wrapping [ErrFileTooLarge] is returned if the file is
+// larger than the limit set with [FS.OpenFullLimit], which defaults
+// to [DefaultOpenFullLimit].
+func (fsys *FS) OpenFull(name string) (io.ReadSeekCloser, error) {
+	if IsRoot(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	id, err := uuid.Parse(name)
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+
+	limit := fsys.openFullLimit
+	if limit <= 0 {
+		limit = DefaultOpenFullLimit
+	}
+
+	const q = `
+		SELECT
+			content_size <= $2,
+			CASE WHEN content_size <= $2 THEN lo_get(oid) END
+		FROM pgfs_metadata
+		WHERE id = $1
+	`
+	var (
+		ok      bool
+		content []byte
+	)
+	err = fsys.conn.QueryRow(q, id, limit).Scan(&ok, &content)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, fs.ErrNotExist
+	case err != nil:
+		return nil, mapError(err)
+	case !ok:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: ErrFileTooLarge}
+	}
+	return &fullFile{r: bytes.NewReader(content)}, nil
+}
+
+// fullFile implements [io.ReadSeekCloser] on top
+// of the content of a file loaded in memory.
+type fullFile struct {
+	r      *bytes.Reader
+	closed bool
+}
+
+// Read implements [io.Reader].
+func (f *fullFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	return f.r.Read(p)
+}
+
+// Seek implements [io.Seeker].
+func (f *fullFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	return f.r.Seek(offset, whence)
+}
+
+// Close implements [io.Closer].
+func (f *fullFile) Close() error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	f.r = nil
+	return nil
+}
+
+var _ io.ReadSeekCloser = &fullFile{}
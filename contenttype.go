@@ -0,0 +1,112 @@
+package pgfs
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"mime"
+	"path"
+
+	"github.com/google/uuid"
+)
+
+// SetContentTypeFromExtension sets the content type of the file with
+// the given name from the extension of filename using [mime.TypeByExtension],
+// and returns it. If filename is empty, the value of [FilenameKey] in the
+// [Sys] of the file is used instead.
+//
+// An error is returned if the extension is unknown.
+func (fsys *FS) SetContentTypeFromExtension(name, filename string) (string, error) {
+	if err := fsys.checkWrite("chtype", name); err != nil {
+		return "", err
+	}
+
+	id, err := uuid.Parse(name)
+	if err != nil {
+		return "", fs.ErrNotExist
+	}
+
+	if filename == "" {
+		const q = `SELECT COALESCE(sys ->> $2, '') FROM pgfs_metadata WHERE id = $1`
+		err := fsys.conn.QueryRow(q, id, FilenameKey).Scan(&filename)
+		if err == sql.ErrNoRows {
+			return "", fs.ErrNotExist
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(filename))
+	if contentType == "" {
+		return "", fmt.Errorf("unknown content type for %q", filename)
+	}
+	if err := fsys.setContentType(id, contentType); err != nil {
+		return "", err
+	}
+	return contentType, nil
+}
+
+// SetContentTypesFromExtensions is the bulk variant of
+// [FS.SetContentTypeFromExtension], and sets the content type
+// of every file with a [FilenameKey] in its [Sys] whose extension
+// is known. It returns the number of files updated.
+func (fsys *FS) SetContentTypesFromExtensions() (int, error) {
+	if err := fsys.checkWrite("chtype", ""); err != nil {
+		return 0, err
+	}
+
+	const q = `
+		SELECT id, content_type, sys ->> $1
+		FROM pgfs_metadata
+		WHERE sys ? $1
+	`
+	rows, err := fsys.conn.Query(q, FilenameKey)
+	if err != nil {
+		return 0, err
+	}
+
+	updates := make(map[uuid.UUID]string)
+	for rows.Next() {
+		var (
+			id                uuid.UUID
+			current, filename string
+		)
+		if err := rows.Scan(&id, &current, &filename); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if contentType := mime.TypeByExtension(path.Ext(filename)); contentType != "" && contentType != current {
+			updates[id] = contentType
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for id, contentType := range updates {
+		if err := fsys.setContentType(id, contentType); err != nil {
+			return 0, err
+		}
+	}
+	return len(updates), nil
+}
+
+// setContentType updates the content type of the file with
+// the given id.
+func (fsys *FS) setContentType(id uuid.UUID, contentType string) error {
+	const q = `
+		UPDATE pgfs_metadata
+		SET content_type = $2, updated_at = NOW()
+		WHERE id = $1
+	`
+	result, err := fsys.conn.Exec(q, id, contentType)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fs.ErrNotExist
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package pgfs
+
+import (
+	"context"
+	"database/sql"
+	"io/fs"
+
+	"github.com/google/uuid"
+)
+
+// copyChunkSize is the size of the chunks copied
+// from one large object to another by [FS.Copy].
+const copyChunkSize = 1 << 20
+
+// Copy creates a new file named dstName with the content of the
+// file named srcName, and returns its info.
+//
+// The content is copied by the server in chunks, so it never
+// leaves the database. The new file shares the content type,
+// digest and raw metadata of the source, and its [Sys] is
+// set to sys.
+//
+// If a file named dstName already exists, an error wrapping
+// [fs.ErrExist] is returned. The new large object is deleted
+// if the copy fails.
+func (fsys *FS) Copy(srcName, dstName string, sys Sys) (FileInfo, error) {
+	if err := fsys.checkWrite("copy", dstName); err != nil {
+		return nil, err
+	}
+
+	if fsys.db != nil {
+		c, tx, err := fsys.begin(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		info, err := c.Copy(srcName, dstName, sys)
+		if err := end(tx, err); err != nil {
+			return nil, err
+		}
+		return info, nil
+	}
+
+	srcID, err := uuid.Parse(srcName)
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+	dstID, err := uuid.Parse(dstName)
+	if err != nil {
+		return nil, &fs.PathError{Op: "copy", Path: dstName, Err: err}
+	}
+
+	const qSrc = `
+		SELECT
+			oid, content_size, content_type, content_sha256, meta,
+			EXISTS(SELECT 1 FROM pgfs_metadata WHERE id = $2)
+		FROM pgfs_metadata
+		WHERE id = $1
+	`
+	var (
+		src    entry
+		exists bool
+	)
+	err = fsys.conn.QueryRow(qSrc, srcID, dstID).Scan(
+		&src.oid,
+		&src.contentSize,
+		&src.contentType,
+		&src.contentSHA256,
+		&src.meta,
+		&exists,
+	)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, fs.ErrNotExist
+	case err != nil:
+		return nil, err
+	case exists:
+		return nil, &fs.PathError{Op: "copy", Path: dstName, Err: fs.ErrExist}
+	}
+
+	var oid OID
+	if err := fsys.conn.QueryRow(`SELECT lo_create(0)`).Scan(&oid); err != nil {
+		return nil, err
+	}
+
+	const qChunk = `SELECT lo_put($1::oid, $2::bigint, lo_get($3::oid, $2::bigint, $4::int))`
+	for off := int64(0); off < src.contentSize; off += copyChunkSize {
+		if _, err := fsys.conn.Exec(qChunk, oid, off, src.oid, copyChunkSize); err != nil {
+			unlink(fsys.conn, oid)
+			return nil, mapError(err)
+		}
+	}
+
+	const qMeta = `
+		INSERT INTO pgfs_metadata (
+			oid, id, sys,
+			content_size, content_type, content_sha256,
+			meta
+		)
+		VALUES (
+			$1, $2, $3,
+			$4, $5, $6,
+			$7
+		)
+	`
+	_, err = fsys.conn.Exec(qMeta, oid, dstID, sys, src.contentSize, src.contentType, src.contentSHA256, src.meta)
+	if err != nil {
+		unlink(fsys.conn, oid)
+		return nil, err
+	}
+
+	info, err := fsys.primary().Stat(dstName)
+	if err != nil {
+		return nil, err
+	}
+	return info.(FileInfo), nil
+}
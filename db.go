@@ -0,0 +1,88 @@
+package pgfs
+
+import (
+	"context"
+	"database/sql"
+)
+
+// NewDB returns a new instance of [FS] bound to a database
+// handle rather than to a transaction.
+//
+// Queries that only read or update metadata, such as [FS.Stat]
+// or [FS.Remove], run in their own transaction, which is committed
+// as soon as they return.
+//
+// Large object descriptors only live as long as the transaction
+// they were opened in, so files returned by [FS.Open] and writers
+// returned by [FS.Create] hold a transaction, and a connection of
+// the pool, until they're closed:
+//   - Files commit it when closed.
+//   - Writers commit it when closed, which makes the new file
+//     visible, and roll it back if an error occurs.
+func NewDB(db *sql.DB) *FS {
+	return &FS{conn: db, db: db}
+}
+
+// NewRW returns a new instance of [FS] that sends writes, such as
+// [FS.Create] and [FS.Remove], to the primary transaction, and
+// reads through [FS.Open], [FS.Stat] and [FS.ReadDir] to the
+// replica transaction.
+//
+// Replicas lag behind the primary, so a file created through
+// the returned [FS] can't be read back until the transaction of
+// the primary is committed and replicated, and the transaction
+// of the replica is started after that. Access tracking (see
+// [FS.TrackAccess]) requires writes, and fails on replicas.
+func NewRW(primary, replica Querier) *FS {
+	return &FS{conn: primary, replica: replica}
+}
+
+// reader returns a copy of fsys bound to the replica
+// passed to [NewRW], or nil if there's none.
+func (fsys *FS) reader() *FS {
+	if fsys.replica == nil {
+		return nil
+	}
+	c := *fsys
+	c.conn = fsys.replica
+	c.replica = nil
+	return &c
+}
+
+// primary returns fsys, or a copy of it whose reads
+// go to the primary passed to [NewRW] if there's one.
+// It's used to read back what was just written.
+func (fsys *FS) primary() *FS {
+	if fsys.replica == nil {
+		return fsys
+	}
+	c := *fsys
+	c.replica = nil
+	return &c
+}
+
+// begin returns a copy of fsys bound to a new transaction
+// of the database handle passed to [NewDB].
+func (fsys *FS) begin(ctx context.Context) (*FS, *sql.Tx, error) {
+	tx, err := fsys.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := *fsys
+	c.conn = tx
+	c.db = nil
+	return &c, tx, nil
+}
+
+// end commits tx if err is nil, and rolls it back otherwise.
+// It returns err as is when tx is nil.
+func end(tx *sql.Tx, err error) error {
+	if tx == nil {
+		return err
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
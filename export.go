@@ -0,0 +1,138 @@
+package pgfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// exportMagic starts the blobs written by [FS.ExportFile].
+const exportMagic = "PGFS"
+
+// exportHeader is the metadata of a file exported
+// with [FS.ExportFile].
+type exportHeader struct {
+	Name          string    `json:"name"`
+	CreatedAt     time.Time `json:"created_at"`
+	ContentType   string    `json:"content_type"`
+	ContentSize   int64     `json:"content_size"`
+	ContentSHA256 []byte    `json:"content_sha256"`
+	Sys           Sys       `json:"sys,omitempty"`
+	Meta          []byte    `json:"meta,omitempty"`
+}
+
+// ExportFile writes the file with the given name to w as a
+// portable blob, which can be imported in another database
+// with [FS.ImportFile].
+//
+// The blob starts with "PGFS", followed by the length of the
+// metadata of the file as a big-endian uint32, the metadata
+// encoded in JSON, and finally the content.
+func (fsys *FS) ExportFile(name string, w io.Writer) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ff, ok := f.(*file)
+	if !ok {
+		return &fs.PathError{Op: "export", Path: name, Err: fs.ErrInvalid}
+	}
+	header, err := json.Marshal(exportHeader{
+		Name:          ff.info.id.String(),
+		CreatedAt:     ff.info.createdAt,
+		ContentType:   ff.info.contentType,
+		ContentSize:   ff.info.contentSize,
+		ContentSHA256: ff.info.contentSHA256,
+		Sys:           ff.info.sys,
+		Meta:          ff.info.meta,
+	})
+	if err != nil {
+		return err
+	}
+
+	prefix := make([]byte, len(exportMagic)+4)
+	copy(prefix, exportMagic)
+	binary.BigEndian.PutUint32(prefix[len(exportMagic):], uint32(len(header)))
+	if _, err := w.Write(prefix); err != nil {
+		return err
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// ImportFile creates a file from a blob written by [FS.ExportFile],
+// under the same name and with the same metadata and creation time,
+// and returns its info.
+//
+// An error wrapping [ErrDigestMismatch] or [ErrSizeMismatch] is
+// returned if the content doesn't match the metadata, in which
+// case the file is discarded.
+func (fsys *FS) ImportFile(r io.Reader) (FileInfo, error) {
+	prefix := make([]byte, len(exportMagic)+4)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, err
+	}
+	if string(prefix[:len(exportMagic)]) != exportMagic {
+		return nil, errors.New("invalid export header")
+	}
+
+	// The whole header is read, so that bytes following
+	// the JSON value aren't mistaken for the content.
+	b := make([]byte, binary.BigEndian.Uint32(prefix[len(exportMagic):]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	var header exportHeader
+	if err := json.Unmarshal(b, &header); err != nil {
+		return nil, err
+	}
+
+	wc, err := fsys.CreateWithOptions(header.Name, CreateOptions{
+		ContentType: header.ContentType,
+		Sys:         header.Sys,
+		RawMeta:     header.Meta,
+	})
+	if err != nil {
+		return nil, err
+	}
+	w := wc.(*writer)
+
+	// Read one more byte than expected to detect
+	// content that is too long.
+	n, err := io.Copy(w, io.LimitReader(r, header.ContentSize+1))
+	switch {
+	case err != nil:
+		break
+	case n != header.ContentSize:
+		err = &fs.PathError{Op: "import", Path: header.Name, Err: ErrSizeMismatch}
+	case !bytes.Equal(w.hasher.Sum(nil), header.ContentSHA256):
+		err = &fs.PathError{Op: "import", Path: header.Name, Err: ErrDigestMismatch}
+	}
+	if err != nil {
+		w.abort()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	const q = `UPDATE pgfs_metadata SET created_at = $2 WHERE id = $1`
+	if _, err := fsys.conn.Exec(q, w.id, header.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	info, err := fsys.primary().Stat(header.Name)
+	if err != nil {
+		return nil, err
+	}
+	return info.(FileInfo), nil
+}
@@ -1,15 +1,17 @@
 package pgfs
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
-	"encoding/base64"
-	"encoding/hex"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/fs"
 	"net/http"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -60,6 +62,19 @@ type FileInfo interface {
 
 	// OID of the object in the database.
 	OID() OID
+
+	// Opaque binary metadata passed with [CreateOptions].
+	RawMeta() []byte
+
+	// CRC-32C checksum of the object's content, if it was
+	// computed with [CreateOptions.ComputeCRC32C].
+	CRC32C() (uint32, bool)
+
+	// Space taken on disk by the object's content, or -1 if
+	// unknown. It's only known for infos returned by the copy
+	// of [FS] returned by [FS.ReportCompressedSize], which
+	// requires the privilege to read pg_largeobject.
+	CompressedSize() int64
 }
 
 // dir is the [fs.File] of the root directory.
@@ -93,13 +108,19 @@ func (d *dir) Readdir(n int) (entries []fs.FileInfo, err error) {
 	const q = `
 	  SELECT 
 			id, oid, created_at, sys,
-			content_size, content_type, content_sha256
+			content_size, content_type, content_sha256, meta
 	  FROM pgfs_metadata
 	  ORDER BY id ASC
 	  OFFSET $1 LIMIT $2
 	`
+	// LIMIT NULL returns all the remaining rows.
+	var limit any
+	if n > 0 {
+		limit = n
+	}
+
 	var rows *sql.Rows
-	rows, err = d.fsys.conn.Query(q, d.cur, n)
+	rows, err = d.fsys.conn.Query(q, d.cur, limit)
 	if err == sql.ErrNoRows {
 		err = io.EOF
 		return
@@ -121,6 +142,7 @@ func (d *dir) Readdir(n int) (entries []fs.FileInfo, err error) {
 			&e.contentSize,
 			&e.contentType,
 			&e.contentSHA256,
+			&e.meta,
 		)
 		if err == sql.ErrNoRows {
 			err = nil
@@ -133,7 +155,7 @@ func (d *dir) Readdir(n int) (entries []fs.FileInfo, err error) {
 		d.cur++
 	}
 
-	if len(entries) < n {
+	if n > 0 && len(entries) < n {
 		err = io.EOF
 	}
 	return
@@ -158,24 +180,61 @@ type entry struct {
 	oid           OID
 	id            uuid.UUID
 	createdAt     time.Time
+	updatedAt     sql.NullTime
 	mode          fs.FileMode
 	contentType   string
 	contentSize   int64
 	contentSHA256 []byte
 	sys           Sys
+	meta          []byte
+	diskSize      sql.NullInt64 // set by [FS.ReportCompressedSize]
 }
 
 func (e *entry) Info() (fs.FileInfo, error) { return e, nil }
 func (e *entry) Type() fs.FileMode          { return e.Mode() }
 func (e *entry) Name() string               { return e.id.String() }
 func (e *entry) Size() int64                { return e.contentSize }
-func (e *entry) ModTime() time.Time         { return e.createdAt }
 func (e *entry) IsDir() bool                { return e.mode.IsDir() }
 func (e *entry) Mode() fs.FileMode          { return e.mode }
 func (e *entry) Sys() any                   { return e.sys }
 func (e *entry) ContentSHA256() []byte      { return e.contentSHA256 }
 func (e *entry) ContentType() string        { return e.contentType }
 func (e *entry) OID() OID                   { return e.oid }
+func (e *entry) RawMeta() []byte            { return e.meta }
+func (e *entry) CRC32C() (uint32, bool)     { return MetaView(e.sys).CRC32C() }
+
+// CompressedSize returns the space taken on disk by the
+// content of the file, or -1 if it wasn't fetched.
+func (e *entry) CompressedSize() int64 {
+	if !e.diskSize.Valid {
+		return -1
+	}
+	return e.diskSize.Int64
+}
+
+// ModTime returns the time stored under [ModTimeKey]
+// if any, and the creation time of the file otherwise.
+func (e *entry) ModTime() time.Time {
+	if t := MetaView(e.sys).OriginalModTime(); !t.IsZero() {
+		return t
+	}
+	return e.createdAt
+}
+
+// stableModTime returns the time stored under [ModTimeKey]
+// if any, and a time derived from the content digest otherwise.
+func (e *entry) stableModTime() time.Time {
+	if t := MetaView(e.sys).OriginalModTime(); !t.IsZero() {
+		return t
+	}
+	// Any date between 1970 and 2000.
+	const span = 30 * 365 * 24 * 60 * 60
+	var sec uint32
+	if len(e.contentSHA256) >= 4 {
+		sec = binary.BigEndian.Uint32(e.contentSHA256)
+	}
+	return time.Unix(int64(sec%span), 0).UTC()
+}
 
 var _ FileInfo = &entry{}
 var _ fs.DirEntry = &entry{}
@@ -183,20 +242,58 @@ var _ fs.DirEntry = &entry{}
 // file implements [fs.File], [http.File],
 // [fs.ReadDirFile] and [http.Handler].
 type file struct {
+	ctx    context.Context
 	fsys   *FS
 	fd     int32
 	pos    int64
 	info   *entry
 	closed bool
+	tx     *sql.Tx    // set by [NewDB], committed on Close
+	flag   int        // set by [FS.OpenFile]
+	dirty  bool       // set when the content changes
+	mu     sync.Mutex // serializes calls to ReadAt
+
+	// stale is set when a read fails, leaving pos out
+	// of sync with the position of the descriptor.
+	stale bool
 }
 
 // ServeHTTP implements [http.Handler].
+//
+// Reads are bound to the context of r, so they stop
+// as soon as the client goes away.
+//
+// Validators are set before calling [http.ServeContent], which
+// relies on them to evaluate conditional headers. In particular,
+// a Range request with an If-Range header matching the ETag gets
+// a partial response, while a stale one gets the whole file.
+//
+// HEAD requests are answered from the info of the file,
+// without seeking or reading the large object.
 func (f *file) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", f.info.contentType)
-	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, hex.EncodeToString(f.info.contentSHA256)))
-	w.Header().Set("Last-Modified", f.info.createdAt.Format(http.TimeFormat))
-	w.Header().Set("Repr-Digest", fmt.Sprintf("sha-256=:%s:", base64.StdEncoding.EncodeToString(f.info.contentSHA256)))
-	http.ServeContent(w, r, f.info.id.String(), f.info.createdAt, f)
+	ctx := f.ctx
+	f.ctx = r.Context()
+	defer func() { f.ctx = ctx }()
+
+	modTime := f.fsys.setHeaders(w, f.info)
+	if r.Method == http.MethodHead {
+		serveHead(w, r, f.info.Size(), modTime)
+		return
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	http.ServeContent(w, r, f.info.id.String(), modTime, f)
+}
+
+// Readdir implements [http.File], and always fails
+// as f isn't a directory.
+func (f *file) Readdir(n int) ([]fs.FileInfo, error) {
+	return nil, &fs.PathError{Op: "readdir", Path: f.info.id.String(), Err: fs.ErrInvalid}
+}
+
+// ReadDir implements [fs.ReadDirFile], and always fails
+// as f isn't a directory.
+func (f *file) ReadDir(n int) ([]fs.DirEntry, error) {
+	return nil, &fs.PathError{Op: "readdir", Path: f.info.id.String(), Err: fs.ErrInvalid}
 }
 
 func (f *file) Stat() (fs.FileInfo, error) {
@@ -204,27 +301,106 @@ func (f *file) Stat() (fs.FileInfo, error) {
 }
 
 func (f *file) Read(p []byte) (int, error) {
-	return read(f.fsys.conn, f.fd, p)
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.info.id.String(), Err: fs.ErrPermission}
+	}
+	n, err := readChunks(f.ctx, f.fsys.conn, f.fd, p, f.fsys.maxReadSize)
+	switch {
+	case err == nil, err == io.EOF:
+		f.pos += int64(n)
+	default:
+		f.stale = true
+	}
+	if err == io.EOF && f.fsys.closeOnEOF {
+		if cErr := f.Close(); cErr != nil {
+			err = cErr
+		}
+	}
+	return n, err
 }
 
+// Seek implements [io.Seeker].
+//
+// Seek(0, io.SeekCurrent) returns the position tracked
+// by f without querying the database.
 func (f *file) Seek(offset int64, whence int) (n int64, err error) {
-	n, err = seek(f.fsys.conn, f.fd, offset, whence)
+	if f.closed {
+		err = fs.ErrClosed
+		return
+	}
+	if offset == 0 && whence == io.SeekCurrent && !f.stale {
+		return f.pos, nil
+	}
+	n, err = seek(f.ctx, f.fsys.conn, f.fd, offset, whence)
 	if err != nil {
 		return
 	}
 	f.pos = n
+	f.stale = false
 	return
 }
 
+// ReadAt implements [io.ReaderAt] by seeking to off before
+// reading, and seeking back to the current position after.
+//
+// Concurrent calls to ReadAt are serialized, but they must
+// not run concurrently with Read or Seek.
+func (f *file) ReadAt(p []byte, off int64) (n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.info.id.String(), Err: fs.ErrPermission}
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.info.id.String(), Err: fs.ErrInvalid}
+	}
+
+	pos, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = seek(f.ctx, f.fsys.conn, f.fd, off, io.SeekStart); err != nil {
+		f.stale = true
+		return 0, err
+	}
+	for n < len(p) && err == nil {
+		var m int
+		m, err = readChunks(f.ctx, f.fsys.conn, f.fd, p[n:], f.fsys.maxReadSize)
+		n += m
+	}
+	if _, sErr := seek(f.ctx, f.fsys.conn, f.fd, pos, io.SeekStart); sErr != nil {
+		f.stale = true
+		if err == nil || err == io.EOF {
+			err = sErr
+		}
+	}
+	return n, err
+}
+
+// Close closes the file descriptor. The file is
+// considered closed even if an error is returned.
+//
+// If the content was modified, its size and digest
+// are updated in the metadata table.
 func (f *file) Close() error {
 	if f.closed {
 		return fs.ErrClosed
 	}
-	err := close(f.fsys.conn, f.fd)
-	if err != nil {
-		f.closed = true
+	f.closed = true
+	err := close(f.ctx, f.fsys.conn, f.fd)
+	if err == nil && f.dirty {
+		err = f.fsys.refresh(f.ctx, f.info.id)
 	}
-	return err
+	return end(f.tx, err)
 }
 
 var _ fs.File = &file{}
+var _ http.File = &file{}
+var _ io.ReaderAt = &file{}
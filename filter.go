@@ -0,0 +1,108 @@
+package pgfs
+
+import (
+	"io"
+	"io/fs"
+)
+
+// Filter returns a read-only view of fsys that only exposes
+// the files for which pred returns true.
+//
+// Predicates are evaluated on the client, after the metadata
+// of the files has been fetched. Listing the root directory
+// of the returned file system therefore loads the metadata
+// of every file in memory.
+func (fsys *FS) Filter(pred func(FileInfo) bool) fs.FS {
+	return &filterFS{fsys: fsys, pred: pred}
+}
+
+// filterFS implements [fs.StatFS] and [fs.ReadDirFS]
+// on top of an [FS] and a predicate.
+type filterFS struct {
+	fsys *FS
+	pred func(FileInfo) bool
+}
+
+// Stat implements [fs.StatFS].
+func (ffs *filterFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := ffs.fsys.Stat(name)
+	if err != nil || IsRoot(name) {
+		return info, err
+	}
+	if !ffs.pred(info.(FileInfo)) {
+		return nil, fs.ErrNotExist
+	}
+	return info, nil
+}
+
+// ReadDir implements [fs.ReadDirFS].
+func (ffs *filterFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	all, err := ffs.fsys.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]fs.DirEntry, 0, len(all))
+	for _, e := range all {
+		if ffs.pred(e.(FileInfo)) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// Open implements [fs.FS].
+func (ffs *filterFS) Open(name string) (fs.File, error) {
+	info, err := ffs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !IsRoot(name) {
+		return ffs.fsys.Open(name)
+	}
+
+	entries, err := ffs.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	return &filterDir{info: info, entries: entries}, nil
+}
+
+var (
+	_ fs.StatFS    = &filterFS{}
+	_ fs.ReadDirFS = &filterFS{}
+)
+
+// filterDir is the [fs.File] of the root directory
+// of a [filterFS].
+type filterDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	closed  bool
+}
+
+func (d *filterDir) Read(p []byte) (int, error) { return 0, fs.ErrInvalid }
+func (d *filterDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+
+func (d *filterDir) Close() error {
+	if d.closed {
+		return fs.ErrClosed
+	}
+	d.closed = true
+	return nil
+}
+
+// ReadDir implements [fs.ReadDirFile].
+func (d *filterDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n > 0 && len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n <= 0 || n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+var _ fs.ReadDirFile = &filterDir{}
@@ -14,7 +14,8 @@
 // [FS] is organized as a flat file system where files use UUID strings as names.
 //
 // Files are meant to be written once, and used as immutable read-only blobs
-// afterwards. They're tracked in a dedicated metadata table called "pgfs_metadata",
+// afterwards, unless they're opened in a write mode with [FS.OpenFile].
+// They're tracked in a dedicated metadata table called "pgfs_metadata",
 // which can be created by calling [MigrateUp]. See [Up] for more information
 // on the schema used.
 //
@@ -62,12 +63,24 @@
 package pgfs
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"io/fs"
 	"log"
+	"math"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -83,25 +96,72 @@ func GenerateUUID() string {
 	return uuid.New().String()
 }
 
+// contentNamespace is the namespace of the UUIDs
+// returned by [UUIDFromContent].
+var contentNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://mohamed.attahri.com/pgfs"))
+
+// UUIDFromContent returns a version 5 UUID string derived from
+// data, so identical content always gets the same name. It can
+// be used with [FS.Exists] to avoid storing duplicates.
+func UUIDFromContent(data []byte) string {
+	return uuid.NewSHA1(contentNamespace, data).String()
+}
+
 // BinaryType is the generic MIME type for
 // binary content.
 const BinaryType = "application/octet-stream"
 
-// Tx represents a database transaction type, such as [sql.Tx].
-type Tx interface {
+// ErrDigestMismatch is returned when the SHA-256 digest of
+// the content of a file does not match the one in its metadata.
+var ErrDigestMismatch = errors.New("content digest mismatch")
+
+// ErrSizeMismatch is returned when the size of the content
+// written does not match the one announced.
+var ErrSizeMismatch = errors.New("content size mismatch")
+
+// ErrAmbiguousPrefix is returned by [FS.OpenPrefix] when
+// several files match a prefix.
+var ErrAmbiguousPrefix = errors.New("ambiguous prefix")
+
+// ErrReadOnly is returned when a file is modified
+// through a read-only [FS].
+var ErrReadOnly = errors.New("read-only file system")
+
+// Querier represents a type that can run queries, such as [sql.Tx].
+// It's all [FS] needs, so it can be bound to a transaction managed
+// by the caller, or to an adapter of another driver.
+//
+// Large objects can only be accessed within a transaction, which
+// must stay open as long as the files are in use.
+//
+// Contexts passed to methods such as [FS.OpenContext] are only
+// honored if the querier also implements QueryContext,
+// QueryRowContext and ExecContext, like [sql.Tx] does.
+type Querier interface {
 	Query(query string, args ...any) (*sql.Rows, error)
 	QueryRow(query string, args ...any) *sql.Row
 	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// Tx represents a database transaction type, such as [sql.Tx].
+type Tx interface {
+	Querier
 	Rollback() error
 	Commit() error
 }
 
 var _ Tx = &sql.Tx{}
 
+// IsRoot reports whether name refers to the root directory,
+// which is the case for an empty string and ".".
+func IsRoot(name string) bool {
+	return name == "" || name == "."
+}
+
 // ValidPath is analog to [fs.ValidPath], and checks
 // if name is a valid UUID.
 func ValidPath(name string) bool {
-	if name == "" { // root directory
+	if IsRoot(name) {
 		return true
 	}
 	_, err := uuid.Parse(name)
@@ -111,41 +171,226 @@ func ValidPath(name string) bool {
 // FS implements a file system using the Large Objects API
 // of Postgres.
 //
-// FS implements [fs.StatFS] and [fs.ReadDirFS].
+// FS implements [fs.StatFS], [fs.ReadDirFS], [fs.ReadFileFS]
+// and [fs.GlobFS].
 type FS struct {
-	conn Tx
+	conn               Querier
+	db                 *sql.DB // set by [NewDB]
+	replica            Querier // set by [NewRW]
+	readOnly           bool
+	lastUpdated        bool
+	stableLastModified bool
+	closeOnEOF         bool
+	trackAccess        bool
+	dataURILimit       int64
+	openFullLimit      int64
+	attachment         bool
+	maxReadSize        int
+	compressedSize     bool
 }
 
 // New returns a new instance of [FS] bound to
-// a database transaction.
-func New(conn Tx) *FS {
+// a database transaction, or any other [Querier]
+// running its queries within one.
+func New(conn Querier) *FS {
 	return &FS{conn: conn}
 }
 
-// ReadFile returns the content of the file with the
-// given name.
+// Tx returns the [Querier] fsys is bound to, to run custom
+// queries, such as joins between [Table] and other tables,
+// that see the changes made through fsys.
+//
+// A few things to keep in mind:
+//   - Committing or rolling back the transaction invalidates
+//     the files and writers that are still open.
+//   - On Postgres, a failing query aborts the transaction,
+//     and every operation of fsys that follows fails too.
+//   - For an [FS] returned by [NewDB], it's the [sql.DB],
+//     and each query runs in its own transaction.
+func (fsys *FS) Tx() Querier {
+	return fsys.conn
+}
+
+// ReadOnly returns a copy of fsys bound to the same
+// transaction, but that rejects operations modifying
+// files with [ErrReadOnly].
+//
+// It's meant to guard read-only transactions, such as
+// those running on a replica, against writes.
+func (fsys *FS) ReadOnly() *FS {
+	c := *fsys
+	c.readOnly = true
+	return &c
+}
+
+// LastUpdated returns a copy of fsys bound to the same
+// transaction, but whose files use the time their metadata
+// was last updated, if any, as Last-Modified when served
+// over HTTP.
+//
+// By default, Last-Modified is set from [fs.FileInfo.ModTime].
+func (fsys *FS) LastUpdated() *FS {
+	c := *fsys
+	c.lastUpdated = true
+	return &c
+}
+
+// StableLastModified returns a copy of fsys bound to the same
+// transaction, but whose files use a Last-Modified that does
+// not depend on when they were inserted in the database when
+// served over HTTP, so it survives backups and restores.
+//
+// The time is read from [ModTimeKey] if present, and derived
+// from the SHA-256 digest of the content otherwise. The latter
+// is an arbitrary date in the past, only meant to be stable.
+//
+// It takes precedence over [FS.LastUpdated].
+func (fsys *FS) StableLastModified() *FS {
+	c := *fsys
+	c.stableLastModified = true
+	return &c
+}
+
+// CloseOnEOF returns a copy of fsys bound to the same
+// transaction, but whose files close their descriptor
+// as soon as a read reaches the end of the content.
+//
+// Reads and seeks that follow return [fs.ErrClosed],
+// so it's not suited to callers seeking back.
+func (fsys *FS) CloseOnEOF() *FS {
+	c := *fsys
+	c.closeOnEOF = true
+	return &c
+}
+
+// TrackAccess returns a copy of fsys bound to the same
+// transaction, but which records the time files are opened,
+// so they can be listed with [FS.RecentlyAccessed].
+//
+// Tracking is opt-in, as it turns every open into a write,
+// and is skipped by the copies returned by [FS.ReadOnly].
+func (fsys *FS) TrackAccess() *FS {
+	c := *fsys
+	c.trackAccess = true
+	return &c
+}
+
+// DataURILimit returns a copy of fsys bound to the same
+// transaction, but whose [FS.DataURI] accepts files of up
+// to n bytes instead of [DefaultDataURILimit].
+func (fsys *FS) DataURILimit(n int64) *FS {
+	c := *fsys
+	c.dataURILimit = n
+	return &c
+}
+
+// OpenFullLimit returns a copy of fsys bound to the same
+// transaction, but whose [FS.OpenFull] accepts files of up
+// to n bytes instead of [DefaultOpenFullLimit].
+func (fsys *FS) OpenFullLimit(n int64) *FS {
+	c := *fsys
+	c.openFullLimit = n
+	return &c
+}
+
+// Attachment returns a copy of fsys bound to the same
+// transaction, but whose files are served over HTTP with a
+// Content-Disposition of "attachment", so browsers download
+// them instead of displaying them.
+func (fsys *FS) Attachment() *FS {
+	c := *fsys
+	c.attachment = true
+	return &c
+}
+
+// MaxReadSize returns a copy of fsys bound to the same
+// transaction, but whose files never request more than n
+// bytes per call to loread. Reads into larger buffers are
+// split into as many calls as needed to fill them.
+func (fsys *FS) MaxReadSize(n int) *FS {
+	c := *fsys
+	c.maxReadSize = n
+	return &c
+}
+
+// ReportCompressedSize returns a copy of fsys bound to the same
+// transaction, but whose [FS.Stat] also fetches the space taken
+// on disk by the content of files, as reported by
+// [FileInfo.CompressedSize] and [FS.PhysicalSize].
+//
+// It's opt-in, as reading pg_largeobject requires superuser
+// privileges, or SELECT being granted on it: for other roles,
+// Stat fails with a permission error. Infos returned without
+// this option report a compressed size of -1.
+func (fsys *FS) ReportCompressedSize() *FS {
+	c := *fsys
+	c.compressedSize = true
+	return &c
+}
+
+// checkWrite returns a [fs.PathError] wrapping [ErrReadOnly]
+// if fsys is read-only.
+func (fsys *FS) checkWrite(op, name string) error {
+	if fsys.readOnly {
+		return &fs.PathError{Op: op, Path: name, Err: ErrReadOnly}
+	}
+	return nil
+}
+
+// ReadFile implements [fs.ReadFileFS], and returns the
+// content of the file with the given name.
 func (fsys *FS) ReadFile(name string) ([]byte, error) {
-	f, err := fsys.Open(name)
+	return fsys.ReadFileContext(context.Background(), name)
+}
+
+// ReadFileContext is like [FS.ReadFile], but the reads
+// are bound to ctx.
+func (fsys *FS) ReadFileContext(ctx context.Context, name string) ([]byte, error) {
+	f, err := fsys.OpenContext(ctx, name)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
-	return io.ReadAll(f)
+
+	// The size is known, so the buffer is allocated once,
+	// with room for the read that returns io.EOF.
+	var buf bytes.Buffer
+	if ff, ok := f.(*file); ok {
+		buf.Grow(int(ff.info.contentSize) + bytes.MinRead)
+	}
+	if _, err := buf.ReadFrom(f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
 // ReadDir implements [fs.ReadDirFS].
 //
-// An error is returned if name is not an empty string.
+// An error is returned if name doesn't refer to the
+// root directory (see [IsRoot]).
 func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fsys.ReadDirContext(context.Background(), name)
+}
+
+// ReadDirContext is like [FS.ReadDir], but the query
+// is bound to ctx.
+func (fsys *FS) ReadDirContext(ctx context.Context, name string) ([]fs.DirEntry, error) {
+	if r := fsys.reader(); r != nil {
+		return r.ReadDirContext(ctx, name)
+	}
+	if !IsRoot(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
 	const q = `
 	  SELECT 
 			id, oid, created_at,
 			sys, content_size, content_type,
-			content_sha256
+			content_sha256, meta
 	  FROM pgfs_metadata
 	  ORDER BY id ASC
 	`
-	rows, err := fsys.conn.Query(q)
+	rows, err := query(ctx, fsys.conn, q)
 	if err != nil {
 		return nil, err
 	}
@@ -162,6 +407,7 @@ func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
 			&e.contentSize,
 			&e.contentType,
 			&e.contentSHA256,
+			&e.meta,
 		)
 		if err != nil {
 			return nil, err
@@ -171,7 +417,173 @@ func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
 	return entries, nil
 }
 
-func (fsys *FS) rootInfo() (fs.FileInfo, error) {
+// queryInfos runs q and returns the info of each row. The
+// query must select the same columns, in the same order,
+// as [FS.ReadDir].
+func (fsys *FS) queryInfos(q string, args ...any) ([]FileInfo, error) {
+	rows, err := fsys.conn.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]FileInfo, 0)
+	defer rows.Close()
+	for rows.Next() {
+		e := &entry{}
+		err := rows.Scan(
+			&e.id,
+			&e.oid,
+			&e.createdAt,
+			&e.sys,
+			&e.contentSize,
+			&e.contentType,
+			&e.contentSHA256,
+			&e.meta,
+		)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, e)
+	}
+	return infos, rows.Err()
+}
+
+// ChangedSince returns the info of the files that were
+// created or updated after t, in chronological order.
+func (fsys *FS) ChangedSince(t time.Time) ([]FileInfo, error) {
+	const q = `
+		SELECT
+			id, oid, created_at,
+			sys, content_size, content_type,
+			content_sha256, meta
+		FROM pgfs_metadata
+		WHERE GREATEST(created_at, updated_at) > $1
+		ORDER BY GREATEST(created_at, updated_at) ASC, id ASC
+	`
+	return fsys.queryInfos(q, t)
+}
+
+// RecentlyAccessed returns the info of up to limit files,
+// starting with the one opened last. Only the files opened
+// through an [FS] returned by [FS.TrackAccess] are listed.
+func (fsys *FS) RecentlyAccessed(limit int) ([]FileInfo, error) {
+	const q = `
+		SELECT
+			id, oid, created_at,
+			sys, content_size, content_type,
+			content_sha256, meta
+		FROM pgfs_metadata
+		WHERE last_accessed_at IS NOT NULL
+		ORDER BY last_accessed_at DESC, id ASC
+		LIMIT $1
+	`
+	return fsys.queryInfos(q, limit)
+}
+
+// FindBySize returns the info of the files with a size
+// between min and max included, ordered by size.
+//
+// If max is negative, the range has no upper bound.
+func (fsys *FS) FindBySize(min, max int64) ([]FileInfo, error) {
+	const q = `
+		SELECT
+			id, oid, created_at,
+			sys, content_size, content_type,
+			content_sha256, meta
+		FROM pgfs_metadata
+		WHERE content_size >= $1 AND ($2::bigint < 0 OR content_size <= $2)
+		ORDER BY content_size ASC, id ASC
+	`
+	return fsys.queryInfos(q, min, max)
+}
+
+// EachPage pages through all the files ordered by name, and
+// calls fn with the info of up to pageSize files at a time.
+//
+// Iteration stops at the first error returned by fn,
+// which is then returned by EachPage.
+func (fsys *FS) EachPage(pageSize int, fn func([]FileInfo) error) error {
+	if pageSize <= 0 {
+		return errors.New("invalid page size")
+	}
+
+	const q = `
+		SELECT
+			id, oid, created_at,
+			sys, content_size, content_type,
+			content_sha256, meta
+		FROM pgfs_metadata
+		WHERE id > $1
+		ORDER BY id ASC
+		LIMIT $2
+	`
+	cursor := rootUUID
+	for {
+		infos, err := fsys.queryInfos(q, cursor, pageSize)
+		if err != nil {
+			return err
+		}
+		if len(infos) == 0 {
+			return nil
+		}
+		if err := fn(infos); err != nil {
+			return err
+		}
+		if len(infos) < pageSize {
+			return nil
+		}
+		cursor = infos[len(infos)-1].(*entry).id
+	}
+}
+
+// ReadDirAfter returns the entries of up to n files whose names
+// come after lastID, ordered by name, along with the cursor to
+// pass as lastID to get the next page. An empty lastID starts
+// from the first file, and an empty cursor means there are no
+// more files.
+//
+// Unlike an offset, the cursor doesn't require the database to
+// scan the files of the previous pages.
+func (fsys *FS) ReadDirAfter(lastID string, n int) ([]fs.DirEntry, string, error) {
+	if n <= 0 {
+		return nil, "", errors.New("invalid page size")
+	}
+	cursor := rootUUID
+	if lastID != "" {
+		id, err := uuid.Parse(lastID)
+		if err != nil {
+			return nil, "", &fs.PathError{Op: "readdir", Path: lastID, Err: fs.ErrInvalid}
+		}
+		cursor = id
+	}
+
+	const q = `
+		SELECT
+			id, oid, created_at,
+			sys, content_size, content_type,
+			content_sha256, meta
+		FROM pgfs_metadata
+		WHERE id > $1
+		ORDER BY id ASC
+		LIMIT $2
+	`
+	infos, err := fsys.queryInfos(q, cursor, n)
+	if err != nil {
+		return nil, "", err
+	}
+
+	entries := make([]fs.DirEntry, len(infos))
+	for i, info := range infos {
+		entries[i] = info.(*entry)
+	}
+	var next string
+	if len(infos) == n {
+		next = infos[n-1].Name()
+	}
+	return entries, next, nil
+}
+
+func (fsys *FS) rootInfo(ctx context.Context) (fs.FileInfo, error) {
 	const q = `
 		WITH agg AS (
 			SELECT SUM(content_size) AS content_size
@@ -188,7 +600,7 @@ func (fsys *FS) rootInfo() (fs.FileInfo, error) {
 		id:   rootUUID,
 		mode: fs.ModeDir,
 	}
-	err := fsys.conn.QueryRow(q).Scan(&fi.createdAt, &fi.contentSize)
+	err := queryRow(ctx, fsys.conn, q).Scan(&fi.createdAt, &fi.contentSize)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
@@ -197,13 +609,22 @@ func (fsys *FS) rootInfo() (fs.FileInfo, error) {
 
 // Stat returns info on the file with the given name.
 //
-// If name is an empty string, the returned info is on the
-// root directory.
+// If name refers to the root directory (see [IsRoot]),
+// the returned info is on the root directory.
 //
 // The returned value implements [FileInfo].
 func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
-	if name == "" {
-		return fsys.rootInfo()
+	return fsys.StatContext(context.Background(), name)
+}
+
+// StatContext is like [FS.Stat], but the query
+// is bound to ctx.
+func (fsys *FS) StatContext(ctx context.Context, name string) (fs.FileInfo, error) {
+	if r := fsys.reader(); r != nil {
+		return r.StatContext(ctx, name)
+	}
+	if IsRoot(name) {
+		return fsys.rootInfo(ctx)
 	}
 
 	id, err := uuid.Parse(name)
@@ -213,12 +634,12 @@ func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
 
 	const q = `
 	  SELECT 
-			oid, created_at, sys,
-			content_size, content_type, content_sha256
+			oid, created_at, updated_at, sys,
+			content_size, content_type, content_sha256, meta
 		FROM pgfs_metadata
 		WHERE id = $1
 	`
-	row := fsys.conn.QueryRow(q, id)
+	row := queryRow(ctx, fsys.conn, q, id)
 	e := &entry{
 		id:   id,
 		mode: 0,
@@ -226,117 +647,1045 @@ func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
 	err = row.Scan(
 		&e.oid,
 		&e.createdAt,
+		&e.updatedAt,
 		&e.sys,
 		&e.contentSize,
 		&e.contentType,
 		&e.contentSHA256,
+		&e.meta,
 	)
 	if err == sql.ErrNoRows {
 		err = fs.ErrNotExist
 	}
+	if err == nil && fsys.compressedSize {
+		const q = `
+			SELECT COALESCE(SUM(pg_column_size(p.*)), 0)
+			FROM pg_largeobject p
+			WHERE p.loid = $1
+		`
+		err = queryRow(ctx, fsys.conn, q, e.oid).Scan(&e.diskSize)
+	}
 	return e, err
 }
 
-// Open returns the file with the given name.
+// Describe returns the content type and size of the file
+// with the given name.
 //
-// If name is an empty string, the root directory
-// is returned.
-func (fsys *FS) Open(name string) (fs.File, error) {
-	if name == "" {
-		di, err := fsys.Stat("")
-		if err != nil {
-			return nil, err
-		}
-		return &dir{fsys: fsys, info: di.(*entry)}, nil
+// It's a lighter alternative to [FS.Stat] when only the
+// values of the Content-Type and Content-Length headers
+// are needed.
+func (fsys *FS) Describe(name string) (contentType string, size int64, err error) {
+	id, err := uuid.Parse(name)
+	if err != nil {
+		err = fs.ErrNotExist
+		return
+	}
+
+	const q = `
+		SELECT content_type, content_size
+		FROM pgfs_metadata
+		WHERE id = $1
+	`
+	err = fsys.conn.QueryRow(q, id).Scan(&contentType, &size)
+	if err == sql.ErrNoRows {
+		err = fs.ErrNotExist
 	}
+	return
+}
 
+// Exists reports whether a file with the given name exists,
+// without fetching its metadata. Invalid names don't exist.
+func (fsys *FS) Exists(name string) (ok bool, err error) {
 	id, err := uuid.Parse(name)
 	if err != nil {
-		return nil, fs.ErrNotExist
+		return false, nil
 	}
 
-	info, fd, err := open(fsys.conn, id, invRead)
+	const q = `SELECT EXISTS(SELECT 1 FROM pgfs_metadata WHERE id = $1)`
+	err = fsys.conn.QueryRow(q, id).Scan(&ok)
+	return
+}
+
+// OIDFor returns the OID of the large object holding the
+// content of the file with the given name.
+func (fsys *FS) OIDFor(name string) (oid OID, err error) {
+	id, err := uuid.Parse(name)
 	if err != nil {
-		return nil, err
+		err = fs.ErrNotExist
+		return
 	}
 
-	f := &file{
-		fd:   fd,
-		fsys: fsys,
-		info: info,
+	const q = `SELECT oid FROM pgfs_metadata WHERE id = $1`
+	err = fsys.conn.QueryRow(q, id).Scan(&oid)
+	if err == sql.ErrNoRows {
+		err = fs.ErrNotExist
 	}
-	return f, nil
+	return
 }
 
-// Create returns a writer to a new file with the given
-// name and content type. The caller must close the writer
-// for the operation to complete.
-//
-// The name must be a valid and unique UUID.
+// Supports64BitSeek reports whether the server supports offsets
+// larger than 2GB in large objects, which is the case from
+// Postgres 9.3 onwards.
+func (fsys *FS) Supports64BitSeek() (bool, error) {
+	const q = `SELECT current_setting('server_version_num')::int >= 90300`
+
+	var ok bool
+	err := fsys.conn.QueryRow(q).Scan(&ok)
+	return ok, err
+}
+
+// LargeObjectCount returns the number of large objects in the
+// database, including those not tracked by [FS].
 //
-// The content type should be a valid MIME type, such as
-// "application/pdf" or "image/png". If an empty string is passed,
-// [http.DetectContentType] will be used to make a guess
-// from the first 512 bytes of data written.
+// A count that drifts away from the number of files
+// is a sign that large objects are leaking.
+func (fsys *FS) LargeObjectCount() (n int64, err error) {
+	const q = `SELECT COUNT(*) FROM pg_largeobject_metadata`
+	err = fsys.conn.QueryRow(q).Scan(&n)
+	return
+}
+
+// Count returns the number of files, without listing them
+// like [FS.ReadDir] does.
+func (fsys *FS) Count() (n int64, err error) {
+	const q = `SELECT COUNT(*) FROM pgfs_metadata`
+	err = fsys.conn.QueryRow(q).Scan(&n)
+	return
+}
+
+// CountWhere returns the number of files whose sys contains
+// all the key-value pairs of filter. An empty filter matches
+// all files.
+func (fsys *FS) CountWhere(filter Sys) (n int64, err error) {
+	const q = `
+		SELECT COUNT(*)
+		FROM pgfs_metadata
+		WHERE COALESCE(sys, '{}'::jsonb) @> COALESCE($1::jsonb, '{}'::jsonb)
+	`
+	err = fsys.conn.QueryRow(q, filter).Scan(&n)
+	return
+}
+
+// TotalSize returns the sum of the sizes of all files,
+// which is also the size of the root directory.
+func (fsys *FS) TotalSize() (n int64, err error) {
+	const q = `SELECT COALESCE(SUM(content_size), 0) FROM pgfs_metadata`
+	err = fsys.conn.QueryRow(q).Scan(&n)
+	return
+}
+
+// PhysicalSize returns an estimate of the space taken on disk by
+// the content of the file with the given name, as opposed to its
+// size, by summing the size of the rows of pg_largeobject holding
+// its pages, headers included.
 //
-// Custom metadata attributes can be passed and stored with the file
-// using sys. They can later be accessed using [fs.FileInfo.Sys]
-// by either opening the file or calling [FS.Stat].
-func (fsys *FS) Create(name, contentType string, sys map[string]string) (io.WriteCloser, error) {
+// Pages are compressed when possible, so the physical size of
+// compressible content can be smaller than its size. Reading
+// pg_largeobject requires superuser privileges.
+func (fsys *FS) PhysicalSize(name string) (n int64, err error) {
 	id, err := uuid.Parse(name)
 	if err != nil {
-		pErr := &fs.PathError{
-			Op:   "create",
-			Path: name,
-			Err:  err,
-		}
-		return nil, pErr
+		err = fs.ErrNotExist
+		return
 	}
 
-	oid, fd, err := create(fsys.conn, id)
-	if err != nil {
-		return nil, err
+	const q = `
+		SELECT COALESCE((
+			SELECT SUM(pg_column_size(p.*))
+			FROM pg_largeobject p
+			WHERE p.loid = m.oid
+		), 0)
+		FROM pgfs_metadata m
+		WHERE m.id = $1
+	`
+	err = fsys.conn.QueryRow(q, id).Scan(&n)
+	if err == sql.ErrNoRows {
+		err = fs.ErrNotExist
 	}
+	return
+}
 
-	w := &writer{
-		fd:          fd,
-		oid:         oid,
-		fsys:        fsys,
-		hasher:      sha256.New(),
-		id:          id,
-		sys:         sys,
-		contentType: contentType,
-	}
-	return w, nil
+// firstNormalOID is the first OID that Postgres assigns to
+// objects created after initdb.
+const firstNormalOID = 16384
+
+// CompressionStats describes the space saved by the compression
+// of the pages of large objects. See [FS.CompressionStats].
+type CompressionStats struct {
+	Size         int64 // sum of the sizes of the files
+	PhysicalSize int64 // sum of their physical sizes
 }
 
-// Remove deletes the file with the given name.
-func (fsys *FS) Remove(name string) error {
-	id, err := uuid.Parse(name)
-	if err != nil {
-		return fs.ErrNotExist
-	}
+// Saved returns the number of bytes saved by compression,
+// which is negative when the overhead of the pages exceeds
+// the savings.
+func (s CompressionStats) Saved() int64 {
+	return s.Size - s.PhysicalSize
+}
 
-	return remove(fsys.conn, id)
+// Ratio returns the physical size divided by the size,
+// or 1 if there is no content.
+func (s CompressionStats) Ratio() float64 {
+	if s.Size == 0 {
+		return 1
+	}
+	return float64(s.PhysicalSize) / float64(s.Size)
 }
 
-var (
-	_ fs.StatFS    = &FS{}
-	_ fs.ReadDirFS = &FS{}
-)
+// CompressionStats returns the sum of the sizes of all files,
+// along with the sum of their physical sizes as reported by
+// [FS.PhysicalSize], which gives the space saved by Postgres
+// compressing the pages of large objects.
+//
+// Reading pg_largeobject requires superuser privileges, or
+// SELECT being granted on it, and CompressionStats fails with
+// a permission error for other roles, which can't measure the
+// savings.
+func (fsys *FS) CompressionStats() (stats CompressionStats, err error) {
+	const q = `
+		SELECT
+			COALESCE(SUM(m.content_size), 0),
+			COALESCE(SUM((
+				SELECT SUM(pg_column_size(p.*))
+				FROM pg_largeobject p
+				WHERE p.loid = m.oid
+			)), 0)
+		FROM pgfs_metadata m
+	`
+	err = fsys.conn.QueryRow(q).Scan(&stats.Size, &stats.PhysicalSize)
+	return
+}
 
-// ServeFile serves the content of a file over HTTP.
+// OIDHeadroom returns the number of large objects in the database,
+// and the maximum number of large objects it can hold, which is
+// bounded by the range of unsigned 32-bit OIDs.
 //
-// If f is a file created by this package, [http.ServeContent]
-// is used after adding the appropriate headers sourced
-// from its [FileInfo].
+// The maximum is a theoretical estimate: OIDs are drawn from a
+// counter shared with the rest of the cluster, and creating large
+// objects gets slower well before the range is exhausted, as the
+// server has to skip over the OIDs already in use.
+func (fsys *FS) OIDHeadroom() (used int64, estimatedMax int64, err error) {
+	used, err = fsys.LargeObjectCount()
+	if err != nil {
+		return
+	}
+	estimatedMax = math.MaxUint32 + 1 - firstNormalOID
+	return
+}
+
+// SizeHistogram returns the number of files in each of the size
+// ranges delimited by buckets, which must be sorted in ascending
+// order.
 //
-//	[...]
-//	Content-Type: application/png                                             // FileInfo.ContentType()
-//	ETag: "0de648a9c8c19264e6cd6a441a867d0989a03929cacec442ad1f0cd192bc9072"  // FileInfo.ContentSHA256()
-//	Last-Modified: Thu, 22 Jun 2023 14:34:35 GMT                              // FileInfo.ModTime()
+// The returned slice holds len(buckets)+1 counts: the first one
+// is for files smaller than buckets[0], the i-th one for files
+// with a size in [buckets[i-1], buckets[i]), and the last one for
+// files at least as large as the last bucket.
+func (fsys *FS) SizeHistogram(buckets []int64) ([]int64, error) {
+	bounds := make([]string, len(buckets))
+	for i, b := range buckets {
+		if i > 0 && b <= buckets[i-1] {
+			return nil, errors.New("buckets must be sorted in ascending order")
+		}
+		bounds[i] = strconv.FormatInt(b, 10)
+	}
+
+	counts := make([]int64, len(buckets)+1)
+	if len(buckets) == 0 {
+		err := fsys.conn.QueryRow(`SELECT COUNT(*) FROM pgfs_metadata`).Scan(&counts[0])
+		return counts, err
+	}
+
+	q := `
+		SELECT
+			width_bucket(content_size, ARRAY[` + strings.Join(bounds, ",") + `]::bigint[]) AS bucket,
+			COUNT(*)
+		FROM pgfs_metadata
+		GROUP BY bucket
+	`
+	rows, err := fsys.conn.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			bucket int
+			count  int64
+		)
+		if err := rows.Scan(&bucket, &count); err != nil {
+			return nil, err
+		}
+		counts[bucket] = count
+	}
+	return counts, rows.Err()
+}
+
+// Duplicates returns the names of the files that share the same
+// content, indexed by the hex-encoded SHA-256 digest of that content.
+//
+// Only digests shared by at least two files are returned.
+func (fsys *FS) Duplicates() (map[string][]string, error) {
+	const q = `
+		SELECT content_sha256, id
+		FROM pgfs_metadata
+		WHERE content_sha256 IN (
+			SELECT content_sha256
+			FROM pgfs_metadata
+			GROUP BY content_sha256
+			HAVING COUNT(*) > 1
+		)
+		ORDER BY content_sha256, id
+	`
+	rows, err := fsys.conn.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dups := make(map[string][]string)
+	for rows.Next() {
+		var (
+			digest []byte
+			id     uuid.UUID
+		)
+		if err := rows.Scan(&digest, &id); err != nil {
+			return nil, err
+		}
+		key := hex.EncodeToString(digest)
+		dups[key] = append(dups[key], id.String())
+	}
+	return dups, rows.Err()
+}
+
+// Open returns the file with the given name.
+//
+// If name refers to the root directory (see [IsRoot]),
+// the root directory is returned.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	return fsys.OpenContext(context.Background(), name)
+}
+
+// OpenContext is like [FS.Open], but opening the file,
+// and later reading from it or seeking in it, are bound
+// to ctx.
+func (fsys *FS) OpenContext(ctx context.Context, name string) (fs.File, error) {
+	if r := fsys.reader(); r != nil {
+		return r.OpenContext(ctx, name)
+	}
+	if IsRoot(name) {
+		di, err := fsys.StatContext(ctx, "")
+		if err != nil {
+			return nil, err
+		}
+		return &dir{fsys: fsys, info: di.(*entry)}, nil
+	}
+
+	id, err := uuid.Parse(name)
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+
+	if fsys.db != nil {
+		c, tx, err := fsys.begin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		f, err := c.OpenContext(ctx, name)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		f.(*file).tx = tx
+		return f, nil
+	}
+
+	info, fd, err := open(ctx, fsys.conn, id, invRead)
+	if err != nil {
+		return nil, err
+	}
+
+	if fsys.trackAccess && !fsys.readOnly {
+		// clock_timestamp() is used rather than NOW(), which
+		// is frozen for the duration of the transaction.
+		const q = `UPDATE pgfs_metadata SET last_accessed_at = clock_timestamp() WHERE id = $1`
+		if _, err := exec(ctx, fsys.conn, q, id); err != nil {
+			close(ctx, fsys.conn, fd)
+			return nil, err
+		}
+	}
+
+	f := &file{
+		ctx:  ctx,
+		fd:   fd,
+		fsys: fsys,
+		info: info,
+	}
+	return f, nil
+}
+
+// SniffContentType guesses the content type of the file with the
+// given name from its first 512 bytes using [http.DetectContentType],
+// regardless of the one stored in its metadata.
+func (fsys *FS) SniffContentType(name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// StreamVerified copies the content of the file with the given
+// name to w, and returns [ErrDigestMismatch] if its SHA-256 digest
+// does not match the one computed when the file was created.
+//
+// Content is streamed as it's read, so w will have received
+// the whole content, corrupted or not, by the time a mismatch
+// is detected. Callers that can't act on content before it's
+// verified should write to a temporary buffer.
+func (fsys *FS) StreamVerified(w io.Writer, name string) (int64, error) {
+	ff, err := fsys.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer ff.Close()
+
+	f, ok := ff.(*file)
+	if !ok {
+		return 0, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), f)
+	if err != nil {
+		return n, err
+	}
+	if !bytes.Equal(h.Sum(nil), f.info.contentSHA256) {
+		return n, ErrDigestMismatch
+	}
+	return n, nil
+}
+
+// ConcatTo copies the content of the files with the given names
+// to w, in order, with sep written between each of them, and
+// returns the number of bytes written.
+//
+// All the files are opened before anything is written, so
+// an error is returned early if one of them does not exist.
+func (fsys *FS) ConcatTo(w io.Writer, names []string, sep []byte) (int64, error) {
+	files := make([]fs.File, 0, len(names))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, name := range names {
+		if IsRoot(name) {
+			return 0, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+		}
+		f, err := fsys.Open(name)
+		if err != nil {
+			return 0, err
+		}
+		files = append(files, f)
+	}
+
+	var total int64
+	for i, f := range files {
+		if i > 0 {
+			n, err := w.Write(sep)
+			total += int64(n)
+			if err != nil {
+				return total, err
+			}
+		}
+		n, err := io.Copy(w, f)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Broadcast reads the content of the file with the given name
+// once, copies it to all the writers, and returns the number of
+// bytes read.
+//
+// The copy stops at the first error returned by a writer.
+// See [io.MultiWriter].
+func (fsys *FS) Broadcast(name string, writers ...io.Writer) (int64, error) {
+	if IsRoot(name) {
+		return 0, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return io.Copy(io.MultiWriter(writers...), f)
+}
+
+// OpenReader opens the file with the given name, and returns a
+// reader whose Read calls fill their buffer entirely, retrying
+// short reads of the large object, unless the end of the content
+// is reached. [io.EOF] is only returned once no bytes are left.
+func (fsys *FS) OpenReader(name string) (io.ReadCloser, error) {
+	if IsRoot(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &fullReader{f: f.(*file)}, nil
+}
+
+// fullReader implements [io.ReadCloser] on top of a [file],
+// and retries short reads until its buffer is full.
+type fullReader struct {
+	f *file
+}
+
+// Read implements [io.Reader].
+func (r *fullReader) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		var m int
+		m, err = r.f.Read(p[n:])
+		n += m
+		if err != nil {
+			break
+		}
+	}
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Close implements [io.Closer].
+func (r *fullReader) Close() error {
+	return r.f.Close()
+}
+
+// OpenPipeline opens the file with the given name, and returns a
+// reader over its content processed by each of the stages in
+// order, such as decompression then decryption. Closing the
+// reader closes the file.
+func (fsys *FS) OpenPipeline(name string, stages ...func(io.Reader) io.Reader) (io.ReadCloser, error) {
+	if IsRoot(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader = f
+	for _, stage := range stages {
+		r = stage(r)
+	}
+	return &pipeline{Reader: r, f: f}, nil
+}
+
+// pipeline is the [io.ReadCloser] returned by [FS.OpenPipeline].
+type pipeline struct {
+	io.Reader
+	f fs.File
+}
+
+// Close implements [io.Closer].
+func (p *pipeline) Close() error {
+	return p.f.Close()
+}
+
+// OpenLines opens the file with the given name, and returns a
+// [bufio.Scanner] over its lines along with a function that
+// must be called to close the file.
+//
+// Lines are limited to [bufio.MaxScanTokenSize] by default, which
+// can be changed by calling [bufio.Scanner.Buffer] before scanning.
+func (fsys *FS) OpenLines(name string) (*bufio.Scanner, func() error, error) {
+	if IsRoot(name) {
+		return nil, nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bufio.NewScanner(f), f.Close, nil
+}
+
+// ReaderAt opens the file with the given name, and returns an
+// [io.ReaderAt] over its content along with its info, which is
+// what random-access readers such as [archive/zip.NewReader] expect.
+//
+// Calls to ReadAt are serialized on a single descriptor.
+// The returned function must be called to close it.
+func (fsys *FS) ReaderAt(name string) (io.ReaderAt, FileInfo, func() error, error) {
+	ff, err := fsys.Open(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	f, ok := ff.(*file)
+	if !ok {
+		ff.Close()
+		return nil, nil, nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return f, f.info, f.Close, nil
+}
+
+// OpenPrefix opens the only file whose name starts with prefix,
+// which is convenient for command-line tools.
+//
+// If no file matches, [fs.ErrNotExist] is returned. If several
+// files match, [ErrAmbiguousPrefix] is returned.
+func (fsys *FS) OpenPrefix(prefix string) (fs.File, error) {
+	prefix = strings.ToLower(prefix)
+	if prefix == "" || strings.Trim(prefix, "0123456789abcdef-") != "" {
+		return nil, fs.ErrNotExist
+	}
+
+	const q = `
+		SELECT id
+		FROM pgfs_metadata
+		WHERE id::text LIKE $1 || '%'
+		LIMIT 2
+	`
+	rows, err := fsys.conn.Query(q, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]uuid.UUID, 0, 2)
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	switch len(ids) {
+	case 0:
+		return nil, fs.ErrNotExist
+	case 1:
+		return fsys.Open(ids[0].String())
+	default:
+		return nil, &fs.PathError{Op: "open", Path: prefix, Err: ErrAmbiguousPrefix}
+	}
+}
+
+// Create returns a writer to a new file with the given
+// name and content type. The caller must close the writer
+// for the operation to complete.
+//
+// The name must be a valid and unique UUID.
+//
+// The content type should be a valid MIME type, such as
+// "application/pdf" or "image/png". If an empty string is passed,
+// [http.DetectContentType] will be used to make a guess
+// from the first 512 bytes of data written.
+//
+// Custom metadata attributes can be passed and stored with the file
+// using sys. They can later be accessed using [fs.FileInfo.Sys]
+// by either opening the file or calling [FS.Stat].
+func (fsys *FS) Create(name, contentType string, sys map[string]string) (io.WriteCloser, error) {
+	return fsys.CreateContext(context.Background(), name, contentType, sys)
+}
+
+// CreateContext is like [FS.Create], but creating the file,
+// and later writing to it and closing it, are bound to ctx.
+//
+// If ctx is done before the writer is closed, the file
+// is discarded.
+func (fsys *FS) CreateContext(ctx context.Context, name, contentType string, sys map[string]string) (io.WriteCloser, error) {
+	return fsys.createWithOptions(ctx, name, CreateOptions{
+		ContentType: contentType,
+		Sys:         sys,
+	})
+}
+
+// CreateOptions holds the optional attributes of
+// a file passed to [FS.CreateWithOptions].
+type CreateOptions struct {
+	// ContentType is the MIME type of the content. See [FS.Create].
+	ContentType string
+
+	// Sys holds custom metadata attributes. See [FS.Create].
+	Sys Sys
+
+	// RawMeta holds opaque binary metadata, such as gob or
+	// msgpack payloads, returned by [FileInfo.RawMeta].
+	RawMeta []byte
+
+	// ComputeCRC32C enables the computation of a CRC-32C
+	// checksum of the content as it's written. It's stored
+	// under [CRC32CKey] and returned by [FileInfo.CRC32C].
+	ComputeCRC32C bool
+
+	// SizeHint is the expected size of the content. When it's
+	// at most [FullDetectionLimit] and ContentType is empty,
+	// the content is buffered so its type can be detected from
+	// all of it, rather than from its first 512 bytes only.
+	SizeHint int64
+}
+
+// CreateWithOptions is like [FS.Create], but accepts
+// all the attributes supported in [CreateOptions].
+func (fsys *FS) CreateWithOptions(name string, opts CreateOptions) (io.WriteCloser, error) {
+	return fsys.createWithOptions(context.Background(), name, opts)
+}
+
+func (fsys *FS) createWithOptions(ctx context.Context, name string, opts CreateOptions) (io.WriteCloser, error) {
+	if err := fsys.checkWrite("create", name); err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.Parse(name)
+	if err != nil {
+		pErr := &fs.PathError{
+			Op:   "create",
+			Path: name,
+			Err:  err,
+		}
+		return nil, pErr
+	}
+
+	if fsys.db != nil {
+		c, tx, err := fsys.begin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		wc, err := c.createWithOptions(ctx, name, opts)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		wc.(*writer).tx = tx
+		return wc, nil
+	}
+
+	oid, fd, err := create(ctx, fsys.conn, id)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &writer{
+		ctx:         ctx,
+		fd:          fd,
+		oid:         oid,
+		fsys:        fsys,
+		hasher:      sha256.New(),
+		id:          id,
+		sys:         opts.Sys,
+		meta:        opts.RawMeta,
+		contentType: opts.ContentType,
+	}
+	switch {
+	case opts.ContentType != "":
+		break
+	case opts.SizeHint > 512 && opts.SizeHint <= FullDetectionLimit:
+		w.tag = make([]byte, 0, opts.SizeHint)
+	default:
+		w.pooledTag = getTag()
+		w.tag = *w.pooledTag
+	}
+	if opts.ComputeCRC32C {
+		w.crc = crc32.New(castagnoli)
+	}
+	return w, nil
+}
+
+// WriteFile creates a new file with the given name and data,
+// and returns its info. It's the equivalent of [os.WriteFile].
+//
+// If the data can't be written, the file is discarded.
+// See [FS.Create] for more details on the other arguments.
+func (fsys *FS) WriteFile(name, contentType string, sys Sys, data []byte) (FileInfo, error) {
+	return fsys.CreateFrom(name, contentType, sys, bytes.NewReader(data))
+}
+
+// CreateFrom creates a new file with the given name and the
+// content of r, such as the body of an HTTP request, and
+// returns its info.
+//
+// If r can't be read in full, the file is discarded.
+// See [FS.Create] for more details on the other arguments.
+func (fsys *FS) CreateFrom(name, contentType string, sys Sys, r io.Reader) (FileInfo, error) {
+	wc, err := fsys.Create(name, contentType, sys)
+	if err != nil {
+		return nil, err
+	}
+	w := wc.(*writer)
+	if _, err := io.Copy(w, r); err != nil {
+		w.abort()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	info, err := fsys.primary().Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return info.(FileInfo), nil
+}
+
+// CreateSized creates a new file with the content of r, which
+// must be exactly size bytes long. It's meant for uploads where
+// the size is announced up front, such as with Content-Length.
+//
+// If r holds fewer or more bytes than size, the file is discarded
+// and [ErrSizeMismatch] is returned.
+//
+// See [FS.Create] for more details on the other arguments.
+func (fsys *FS) CreateSized(name, contentType string, size int64, sys Sys, r io.Reader) (FileInfo, error) {
+	wc, err := fsys.Create(name, contentType, sys)
+	if err != nil {
+		return nil, err
+	}
+	w := wc.(*writer)
+
+	// Read one more byte than expected to detect
+	// readers that are too long.
+	n, err := io.Copy(w, io.LimitReader(r, size+1))
+	if err == nil && n != size {
+		err = &fs.PathError{Op: "create", Path: name, Err: ErrSizeMismatch}
+	}
+	if err != nil {
+		w.abort()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	info, err := fsys.primary().Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return info.(FileInfo), nil
+}
+
+// SetSys replaces the [Sys] of the file with the given
+// name with sys, without rewriting its content.
+func (fsys *FS) SetSys(name string, sys Sys) error {
+	const q = `
+		UPDATE pgfs_metadata
+		SET sys = $2, updated_at = NOW()
+		WHERE id = $1
+	`
+	return fsys.updateSys("setsys", name, q, sys)
+}
+
+// MergeSys adds the keys of patch to the [Sys] of the file
+// with the given name, overwriting existing ones.
+func (fsys *FS) MergeSys(name string, patch Sys) error {
+	const q = `
+		UPDATE pgfs_metadata
+		SET
+			sys = COALESCE(sys, '{}'::jsonb) || COALESCE($2::jsonb, '{}'::jsonb),
+			updated_at = NOW()
+		WHERE id = $1
+	`
+	return fsys.updateSys("mergesys", name, q, patch)
+}
+
+// updateSys runs q, which updates the sys of the file
+// identified by $1 with $2.
+func (fsys *FS) updateSys(op, name, q string, sys Sys) error {
+	if err := fsys.checkWrite(op, name); err != nil {
+		return err
+	}
+
+	id, err := uuid.Parse(name)
+	if err != nil {
+		return fs.ErrNotExist
+	}
+
+	result, err := fsys.conn.Exec(q, id, sys)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err == nil && n == 0 {
+		err = fs.ErrNotExist
+	}
+	return err
+}
+
+// IncrSys atomically adds delta to the integer stored under key
+// in the [Sys] of the file with the given name, and returns the
+// new value. A missing key is treated as zero.
+//
+// The value is stored as a string, like every other value of [Sys].
+func (fsys *FS) IncrSys(name, key string, delta int64) (n int64, err error) {
+	if err = fsys.checkWrite("incr", name); err != nil {
+		return
+	}
+
+	id, err := uuid.Parse(name)
+	if err != nil {
+		err = fs.ErrNotExist
+		return
+	}
+
+	const q = `
+		UPDATE pgfs_metadata
+		SET
+			sys = jsonb_set(
+				COALESCE(sys, '{}'::jsonb),
+				ARRAY[$2::text],
+				to_jsonb((COALESCE((sys ->> $2::text)::bigint, 0) + $3::bigint)::text)
+			),
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING (sys ->> $2::text)::bigint
+	`
+	err = fsys.conn.QueryRow(q, id, key, delta).Scan(&n)
+	if err == sql.ErrNoRows {
+		err = fs.ErrNotExist
+	}
+	return
+}
+
+// SetSysWhere merges patch into the [Sys] of all the files whose
+// value for filterKey is filterValue, and returns the number of
+// files updated.
+//
+// Keys of patch overwrite existing ones.
+func (fsys *FS) SetSysWhere(filterKey, filterValue string, patch Sys) (updated int, err error) {
+	if err = fsys.checkWrite("setsys", filterKey); err != nil {
+		return
+	}
+
+	const q = `
+		UPDATE pgfs_metadata
+		SET
+			sys = COALESCE(sys, '{}'::jsonb) || COALESCE($3::jsonb, '{}'::jsonb),
+			updated_at = NOW()
+		WHERE sys ->> $1::text = $2::text
+	`
+	result, err := fsys.conn.Exec(q, filterKey, filterValue, patch)
+	if err != nil {
+		return
+	}
+	n, err := result.RowsAffected()
+	updated = int(n)
+	return
+}
+
+// Remove deletes the file with the given name.
+func (fsys *FS) Remove(name string) error {
+	return fsys.RemoveContext(context.Background(), name)
+}
+
+// RemoveContext is like [FS.Remove], but the query
+// is bound to ctx.
+func (fsys *FS) RemoveContext(ctx context.Context, name string) error {
+	if err := fsys.checkWrite("remove", name); err != nil {
+		return err
+	}
+
+	id, err := uuid.Parse(name)
+	if err != nil {
+		return fs.ErrNotExist
+	}
+
+	return remove(ctx, fsys.conn, id)
+}
+
+// Glob implements [fs.GlobFS].
+//
+// Patterns made of a prefix of a name followed by "*", such as
+// "d7f225*", are matched by the database. Other patterns are
+// matched with [path.Match] against the names of all files.
+func (fsys *FS) Glob(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	prefix, ok := strings.CutSuffix(pattern, "*")
+	if !ok || strings.Trim(prefix, "0123456789abcdef-") != "" {
+		entries, err := fsys.ReadDir(".")
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, e := range entries {
+			if ok, _ := path.Match(pattern, e.Name()); ok {
+				names = append(names, e.Name())
+			}
+		}
+		return names, nil
+	}
+
+	const q = `
+		SELECT id
+		FROM pgfs_metadata
+		WHERE id::text LIKE $1 || '%'
+		ORDER BY id ASC
+	`
+	rows, err := fsys.conn.Query(q, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		names = append(names, id.String())
+	}
+	return names, rows.Err()
+}
+
+var (
+	_ fs.StatFS     = &FS{}
+	_ fs.ReadDirFS  = &FS{}
+	_ fs.GlobFS     = &FS{}
+	_ fs.ReadFileFS = &FS{}
+)
+
+// ServeFile serves the content of a file over HTTP.
+//
+// If f is a file created by this package, [http.ServeContent]
+// is used after adding the appropriate headers sourced
+// from its [FileInfo].
+//
+//	[...]
+//	Content-Type: application/png                                             // FileInfo.ContentType()
+//	ETag: "0de648a9c8c19264e6cd6a441a867d0989a03929cacec442ad1f0cd192bc9072"  // FileInfo.ContentSHA256()
+//	Last-Modified: Thu, 22 Jun 2023 14:34:35 GMT                              // FileInfo.ModTime()
 //	Repr-Digest: sha-256=:DeZIqcjBkmTmzWpEGoZ9CYmgOSnKzsRCrR8M0ZK8kHI=:       // FileInfo.ContentSHA256()
+//	Content-Disposition: inline; filename=gopher.png                          // Sys[FilenameKey]
 //	[...]
+//
+// Content-Disposition is only set if the [Sys] of the file holds a
+// [FilenameKey], or if it was opened through [FS.Attachment].
+//
+// Other files are served with [http.ServeContent] if they
+// implement [io.Seeker], and copied as is otherwise, in which
+// case conditional GET and HEAD requests are evaluated against
+// their info.
 func ServeFile(w http.ResponseWriter, r *http.Request, f fs.File) {
 	if handler, ok := f.(http.Handler); ok {
 		handler.ServeHTTP(w, r)
@@ -355,12 +1704,31 @@ func ServeFile(w http.ResponseWriter, r *http.Request, f fs.File) {
 		return
 	}
 
-	if rsc, ok := f.(io.ReadSeekCloser); ok {
-		http.ServeContent(w, r, info.Name(), info.ModTime(), rsc)
+	// Any seekable file, such as those of embed.FS,
+	// supports range and conditional requests.
+	if rs, ok := f.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, info.Name(), info.ModTime(), rs)
 		return
 	}
 
-	w.Header().Set("Last-Modified", info.ModTime().Format(http.TimeFormat))
+	// Conditional requests can still be answered from the
+	// info, with an ETag if it's a [FileInfo].
+	var etag string
+	if fi, ok := info.(FileInfo); ok {
+		etag = fmt.Sprintf(`"%s"`, hex.EncodeToString(fi.ContentSHA256()))
+		w.Header().Set("ETag", etag)
+	}
+	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	if notModified(r, etag, info.ModTime()) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 	if _, err := io.Copy(w, f); err != nil {
 		log.Printf("error copying file to response: %v", err)
 	}
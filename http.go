@@ -0,0 +1,368 @@
+package pgfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrUnsupportedDigest is returned by [ParseReprDigest] when
+// a header holds no digest computed with a supported algorithm.
+var ErrUnsupportedDigest = errors.New("unsupported digest algorithm")
+
+// ErrFileTooLarge is returned by [FS.DataURI] when a file
+// exceeds the size limit of data URIs.
+var ErrFileTooLarge = errors.New("file too large")
+
+// DefaultDataURILimit is the size, in bytes, of the largest file
+// accepted by [FS.DataURI], unless changed with [FS.DataURILimit].
+const DefaultDataURILimit = 32 << 10
+
+// errMalformedDigest is returned by [ParseReprDigest] when
+// a header can't be parsed.
+var errMalformedDigest = errors.New("malformed Repr-Digest header")
+
+// digestSizes maps the digest algorithms supported by
+// [ParseReprDigest], in order of preference, to the size
+// of their output.
+var digestSizes = []struct {
+	algo string
+	size int
+}{
+	{"sha-256", sha256.Size},
+	{"sha-512", sha512.Size},
+}
+
+// ReprDigest returns the value of a [Repr-Digest] header for
+// the given algorithm, such as "sha-256" or "sha-512", and digest.
+//
+//	ReprDigest("sha-256", info.ContentSHA256()) // sha-256=:DeZIqcjBkmTmzWpEGoZ9CYmgOSnKzsRCrR8M0ZK8kHI=:
+//
+// [Repr-Digest]: https://www.rfc-editor.org/rfc/rfc9530#name-the-repr-digest-field
+func ReprDigest(algo string, digest []byte) string {
+	return fmt.Sprintf("%s=:%s:", algo, base64.StdEncoding.EncodeToString(digest))
+}
+
+// ParseReprDigest parses the value of a [Repr-Digest] header, such
+// as one sent by a client along with an upload, and returns the
+// algorithm and the digest it holds.
+//
+// If the header holds several digests, "sha-256" is preferred
+// over "sha-512". Other algorithms are ignored, and
+// [ErrUnsupportedDigest] is returned if none is left.
+//
+// [Repr-Digest]: https://www.rfc-editor.org/rfc/rfc9530#name-the-repr-digest-field
+func ParseReprDigest(header string) (algo string, digest []byte, err error) {
+	digests := make(map[string][]byte)
+	for _, member := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(member), "=")
+		if !ok || key == "" {
+			return "", nil, errMalformedDigest
+		}
+		// Parameters are ignored.
+		value, _, _ = strings.Cut(value, ";")
+		if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+			return "", nil, errMalformedDigest
+		}
+		b, err := base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+		if err != nil {
+			return "", nil, errMalformedDigest
+		}
+		digests[strings.ToLower(key)] = b
+	}
+
+	for _, d := range digestSizes {
+		b, ok := digests[d.algo]
+		if !ok {
+			continue
+		}
+		if len(b) != d.size {
+			return "", nil, errMalformedDigest
+		}
+		return d.algo, b, nil
+	}
+	return "", nil, ErrUnsupportedDigest
+}
+
+// CreateFromURL downloads the content at url with client, and
+// streams it into a new file with the given name. If client is
+// nil, [http.DefaultClient] is used.
+//
+// If contentType is empty, the Content-Type of the response is
+// used as the content type of the file, and it's guessed from the
+// content if missing. The url is stored in [Sys] under [SourceURLKey].
+//
+// Both the download and the writes are bound to ctx. An error
+// is returned if the response status is not 200.
+func (fsys *FS) CreateFromURL(ctx context.Context, name, url, contentType string, client *http.Client) (FileInfo, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status fetching %s: %s", url, resp.Status)
+	}
+
+	if contentType == "" {
+		contentType = resp.Header.Get("Content-Type")
+	}
+	wc, err := fsys.CreateContext(ctx, name, contentType, Sys{SourceURLKey: url})
+	if err != nil {
+		return nil, err
+	}
+	w := wc.(*writer)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		w.abort()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	info, err := fsys.primary().Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return info.(FileInfo), nil
+}
+
+// MatchETag reports whether etag, in its strong ("...") or weak
+// (W/"...") form, matches the ETag served for the file with the
+// given name, which is the hex-encoded SHA-256 digest of its content.
+func (fsys *FS) MatchETag(name, etag string) (bool, error) {
+	id, err := uuid.Parse(name)
+	if err != nil {
+		return false, fs.ErrNotExist
+	}
+
+	const q = `SELECT content_sha256 FROM pgfs_metadata WHERE id = $1`
+	var digest []byte
+	err = fsys.conn.QueryRow(q, id).Scan(&digest)
+	if err == sql.ErrNoRows {
+		return false, fs.ErrNotExist
+	}
+	if err != nil {
+		return false, err
+	}
+
+	etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+	if len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		return false, nil
+	}
+	b, err := hex.DecodeString(etag[1 : len(etag)-1])
+	if err != nil {
+		return false, nil
+	}
+	return bytes.Equal(b, digest), nil
+}
+
+// setHeaders sets the Content-Type, ETag, Last-Modified,
+// Repr-Digest and Content-Disposition headers of the response
+// serving e, and returns the modification time it advertises.
+func (fsys *FS) setHeaders(w http.ResponseWriter, e *entry) time.Time {
+	modTime := e.ModTime()
+	switch {
+	case fsys.stableLastModified:
+		modTime = e.stableModTime()
+	case fsys.lastUpdated && e.updatedAt.Valid:
+		modTime = e.updatedAt.Time
+	}
+
+	w.Header().Set("Content-Type", e.contentType)
+	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, hex.EncodeToString(e.contentSHA256)))
+	w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	w.Header().Set("Repr-Digest", ReprDigest("sha-256", e.contentSHA256))
+
+	disposition := "inline"
+	if fsys.attachment {
+		disposition = "attachment"
+	}
+	if filename := MetaView(e.sys).Filename(); filename != "" {
+		// Non-ASCII names are encoded as per RFC 2231.
+		if v := mime.FormatMediaType(disposition, map[string]string{"filename": filename}); v != "" {
+			disposition = v
+		}
+	}
+	if disposition != "inline" {
+		w.Header().Set("Content-Disposition", disposition)
+	}
+	return modTime
+}
+
+// HTTPFileSystem returns an [http.FileSystem] serving the files of
+// fsys, so they can be mounted with [http.FileServer], which passes
+// names with a leading slash such as "/d7f225c4-...". The root
+// directory is listed for "/".
+func HTTPFileSystem(fsys *FS) http.FileSystem {
+	return &httpFileSystem{fsys: fsys}
+}
+
+// httpFileSystem is the [http.FileSystem]
+// returned by [HTTPFileSystem].
+type httpFileSystem struct {
+	fsys *FS
+}
+
+// Open implements [http.FileSystem].
+func (hfs *httpFileSystem) Open(name string) (http.File, error) {
+	f, err := hfs.fsys.Open(strings.TrimPrefix(name, "/"))
+	if err != nil {
+		return nil, err
+	}
+	return f.(http.File), nil
+}
+
+// serveHead answers a HEAD request with the headers set by
+// [FS.setHeaders] and the given size, without reading the content.
+func serveHead(w http.ResponseWriter, r *http.Request, size int64, modTime time.Time) {
+	w.Header().Set("Accept-Ranges", "bytes")
+	if notModified(r, w.Header().Get("ETag"), modTime) {
+		writeNotModified(w)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeNotModified writes a 304 Not Modified response, without
+// the headers that only describe a body.
+func writeNotModified(w http.ResponseWriter) {
+	h := w.Header()
+	delete(h, "Content-Type")
+	delete(h, "Content-Length")
+	delete(h, "Content-Encoding")
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// notModified reports whether the conditional headers of r allow
+// a 304 Not Modified response for a file with the given ETag and
+// modification time.
+//
+// As in [http.ServeContent], If-Modified-Since is ignored when the
+// request has an If-None-Match header.
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || modTime.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	return !modTime.Truncate(time.Second).After(t)
+}
+
+// ServeName serves the content of the file with the given name
+// over HTTP, like [ServeFile].
+//
+// Conditional GET and HEAD requests are evaluated against the
+// metadata of the file before its large object is opened, so a
+// 304 Not Modified response doesn't cost a call to lo_open.
+func (fsys *FS) ServeName(w http.ResponseWriter, r *http.Request, name string) {
+	info, err := fsys.Stat(name)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		log.Printf("error reading file stat: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	case info.IsDir():
+		http.NotFound(w, r)
+		return
+	}
+
+	modTime := fsys.setHeaders(w, info.(*entry))
+	if r.Method == http.MethodHead {
+		serveHead(w, r, info.Size(), modTime)
+		return
+	}
+	if notModified(r, w.Header().Get("ETag"), modTime) {
+		writeNotModified(w)
+		return
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		log.Printf("error opening file: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	ServeFile(w, r, f)
+}
+
+// DataURI returns the content of the file with the given name
+// as a data URI, such as "data:image/png;base64,...", to inline
+// small assets in documents.
+//
+// An error wrapping [ErrFileTooLarge] is returned if the file is
+// larger than the limit set with [FS.DataURILimit], which defaults
+// to [DefaultDataURILimit].
+func (fsys *FS) DataURI(name string) (string, error) {
+	if IsRoot(name) {
+		return "", &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+
+	limit := fsys.dataURILimit
+	if limit <= 0 {
+		limit = DefaultDataURILimit
+	}
+
+	ff, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer ff.Close()
+
+	f := ff.(*file)
+	if f.info.contentSize > limit {
+		return "", &fs.PathError{Op: "read", Path: name, Err: ErrFileTooLarge}
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	return "data:" + f.info.contentType + ";base64," + base64.StdEncoding.EncodeToString(b), nil
+}
@@ -1,6 +1,7 @@
 package pgfs
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -16,28 +17,105 @@ const (
 	invWrite = 0x00040000
 )
 
+// maxSize is the maximum size of a large object
+// on Postgres (4TB).
+const maxSize = (1<<31 - 1) * 2048
+
+// ErrLargeObjectFull is returned when a write would grow
+// a large object beyond the maximum size supported by Postgres.
+var ErrLargeObjectFull = errors.New("large object size limit reached")
+
+// ErrTimeout is returned when an operation on a large
+// object is canceled by a statement timeout.
+var ErrTimeout = errors.New("large object operation timed out")
+
+// sqlStateError is implemented by errors of Postgres
+// drivers that expose the SQLSTATE code of an error.
+type sqlStateError interface {
+	SQLState() string
+}
+
+// isLargeObjectFull reports whether err was raised by
+// lowrite because the write would exceed [maxSize], which
+// Postgres reports as invalid_parameter_value.
+func isLargeObjectFull(err error) bool {
+	var sErr sqlStateError
+	return errors.As(err, &sErr) && sErr.SQLState() == "22023"
+}
+
+// mapError returns an error wrapping both err and
+// [ErrTimeout] if err was caused by a statement timeout,
+// and err otherwise.
+func mapError(err error) error {
+	var sErr sqlStateError
+	if errors.As(err, &sErr) && sErr.SQLState() == "57014" {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return err
+}
+
 // OID is the internal ID of a large object
 // on Postgres.
 type OID uint32
 
+// contextTx is implemented by transactions that
+// support contexts, such as [sql.Tx].
+type contextTx interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+var _ contextTx = &sql.Tx{}
+
+// query is analog to [sql.Tx.QueryContext], and falls
+// back to Query when conn doesn't support contexts.
+func query(ctx context.Context, conn Querier, query string, args ...any) (*sql.Rows, error) {
+	if c, ok := conn.(contextTx); ok {
+		return c.QueryContext(ctx, query, args...)
+	}
+	return conn.Query(query, args...)
+}
+
+// queryRow is analog to [sql.Tx.QueryRowContext], and falls
+// back to QueryRow when conn doesn't support contexts.
+func queryRow(ctx context.Context, conn Querier, query string, args ...any) *sql.Row {
+	if c, ok := conn.(contextTx); ok {
+		return c.QueryRowContext(ctx, query, args...)
+	}
+	return conn.QueryRow(query, args...)
+}
+
+// exec is analog to [sql.Tx.ExecContext], and falls
+// back to Exec when conn doesn't support contexts.
+func exec(ctx context.Context, conn Querier, query string, args ...any) (sql.Result, error) {
+	if c, ok := conn.(contextTx); ok {
+		return c.ExecContext(ctx, query, args...)
+	}
+	return conn.Exec(query, args...)
+}
+
 // open returns info and a file descriptor for an existing
 // large object.
-func open(conn Tx, id uuid.UUID, mode int) (info *entry, fd int32, err error) {
+func open(ctx context.Context, conn Querier, id uuid.UUID, mode int) (info *entry, fd int32, err error) {
 	const q = `
 		SELECT 
-			oid, created_at,
-			content_size, content_type, content_sha256,
+			oid, created_at, updated_at, sys,
+			content_size, content_type, content_sha256, meta,
 			lo_open(oid, $2) as fd
 		FROM pgfs_metadata
 		WHERE id = $1
 	`
 	info = &entry{id: id}
-	err = conn.QueryRow(q, id, mode).Scan(
+	err = queryRow(ctx, conn, q, id, mode).Scan(
 		&info.oid,
 		&info.createdAt,
+		&info.updatedAt,
+		&info.sys,
 		&info.contentSize,
 		&info.contentType,
 		&info.contentSHA256,
+		&info.meta,
 		&fd,
 	)
 	switch {
@@ -54,7 +132,7 @@ func open(conn Tx, id uuid.UUID, mode int) (info *entry, fd int32, err error) {
 // create creates and opens a new large object for writing
 // if no other object with the same name exists in the metadata
 // table.
-func create(conn Tx, id uuid.UUID) (oid OID, fd int32, err error) {
+func create(ctx context.Context, conn Querier, id uuid.UUID) (oid OID, fd int32, err error) {
 	const q = `
 		WITH 
 			meta AS (
@@ -71,7 +149,7 @@ func create(conn Tx, id uuid.UUID) (oid OID, fd int32, err error) {
 			lo_open((SELECT oid FROM lob), $2) as fd
 		WHERE EXISTS (SELECT oid FROM lob)
 	`
-	err = conn.QueryRow(q, id, invRead|invWrite).Scan(&oid, &fd)
+	err = queryRow(ctx, conn, q, id, invRead|invWrite).Scan(&oid, &fd)
 	switch {
 	case err == sql.ErrNoRows:
 		err = fs.ErrExist
@@ -85,59 +163,126 @@ func create(conn Tx, id uuid.UUID) (oid OID, fd int32, err error) {
 
 // write is analog to [io.Writer], and writes b
 // in the file fd.
-func write(conn Tx, fd int32, b []byte) (n int, err error) {
+//
+// lowrite can write fewer bytes than requested, so
+// it's called until b is written in full.
+func write(ctx context.Context, conn Querier, fd int32, b []byte) (n int, err error) {
 	const q = `SELECT lowrite($1, $2)`
 
-	err = conn.QueryRow(q, fd, b).Scan(&n)
-	switch {
-	case err != nil:
-		break
-	case n < 0:
-		err = errors.New("error writing to large object")
-	case n < len(b):
-		err = io.ErrShortWrite
+	for n < len(b) {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		var m int
+		err = queryRow(ctx, conn, q, fd, b[n:]).Scan(&m)
+		switch {
+		case err != nil && isLargeObjectFull(err):
+			err = ErrLargeObjectFull
+		case err != nil:
+			err = mapError(err)
+		case m < 0:
+			err = errors.New("error writing to large object")
+		case m == 0:
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			return
+		}
+		n += m
 	}
 	return
 }
 
 // seek is analog to [io.Seeker], and changes the read/write
 // position in fd.
-func seek(conn Tx, fd int32, offset int64, whence int) (n int64, err error) {
+func seek(ctx context.Context, conn Querier, fd int32, offset int64, whence int) (n int64, err error) {
 	const q = `SELECT lo_lseek64($1, $2, $3)`
 
-	err = conn.QueryRow(q, fd, offset, whence).Scan(&n)
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	err = queryRow(ctx, conn, q, fd, offset, whence).Scan(&n)
 	switch {
 	case err != nil:
-		break
+		err = mapError(err)
 	case n == -1:
 		err = errors.New("error seeking position in large object")
 	}
 	return
 }
 
-// read is analog to [io.Reader], and fills p with len(p)
-// bytes from the file fd.
-func read(conn Tx, fd int32, p []byte) (n int, err error) {
+// read is analog to [io.Reader], and fills p with up to
+// len(p) bytes from the file fd.
+//
+// loread can return fewer bytes than requested before the
+// end of the content, so [io.EOF] is only returned when
+// no bytes are left to read.
+func read(ctx context.Context, conn Querier, fd int32, p []byte) (n int, err error) {
 	const q = `SELECT loread($1, $2)`
 
+	if err = ctx.Err(); err != nil {
+		return
+	}
 	buf := make([]byte, 0, len(p))
-	err = conn.QueryRow(q, fd, len(p)).Scan(&buf)
+	err = queryRow(ctx, conn, q, fd, len(p)).Scan(&buf)
 	if err != nil {
+		err = mapError(err)
 		return
 	}
-	if len(p) != len(buf) {
+	if len(buf) == 0 && len(p) > 0 {
 		err = io.EOF
 	}
 	n = copy(p, buf)
 	return
 }
 
+// readChunks is like read, but requests at most limit bytes per
+// call to loread, and calls it until p is full. A non-positive
+// limit disables chunking.
+func readChunks(ctx context.Context, conn Querier, fd int32, p []byte, limit int) (n int, err error) {
+	if limit <= 0 || len(p) <= limit {
+		return read(ctx, conn, fd, p)
+	}
+	for n < len(p) {
+		chunk := p[n:]
+		if len(chunk) > limit {
+			chunk = chunk[:limit]
+		}
+		var m int
+		m, err = read(ctx, conn, fd, chunk)
+		n += m
+		if err != nil || m < len(chunk) {
+			break
+		}
+	}
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return
+}
+
+// truncate is analog to [os.File.Truncate], and changes
+// the size of the file fd. Growing it fills it with zeros.
+func truncate(ctx context.Context, conn Querier, fd int32, size int64) (err error) {
+	const q = `SELECT lo_truncate64($1, $2)`
+
+	var result int
+	err = queryRow(ctx, conn, q, fd, size).Scan(&result)
+	switch {
+	case err != nil:
+		err = mapError(err)
+	case result == -1:
+		err = errors.New("error truncating large object")
+	}
+	return
+}
+
 // close closes the file.
-func close(conn Tx, fd int32) (err error) {
+func close(ctx context.Context, conn Querier, fd int32) (err error) {
 	const q = `SELECT lo_close($1)`
 
 	var result int
-	err = conn.QueryRow(q, fd).Scan(&result)
+	err = queryRow(ctx, conn, q, fd).Scan(&result)
 	switch {
 	case err != nil:
 		break
@@ -149,7 +294,7 @@ func close(conn Tx, fd int32) (err error) {
 
 // remove deletes the large object with the given
 // name, along with its metadata row.
-func remove(conn Tx, id uuid.UUID) (err error) {
+func remove(ctx context.Context, conn Querier, id uuid.UUID) (err error) {
 	const q = `
 		WITH meta AS (
 			DELETE FROM pgfs_metadata
@@ -161,7 +306,7 @@ func remove(conn Tx, id uuid.UUID) (err error) {
 	`
 
 	var result int
-	err = conn.QueryRow(q, id).Scan(&result)
+	err = queryRow(ctx, conn, q, id).Scan(&result)
 	switch {
 	case err == sql.ErrNoRows:
 		err = fs.ErrNotExist
@@ -172,3 +317,15 @@ func remove(conn Tx, id uuid.UUID) (err error) {
 	}
 	return
 }
+
+// unlink deletes the large object with the given oid.
+func unlink(conn Querier, oid OID) (err error) {
+	const q = `SELECT lo_unlink($1)`
+
+	var result int
+	err = conn.QueryRow(q, oid).Scan(&result)
+	if err == nil && result == -1 {
+		err = errors.New("error deleting large object")
+	}
+	return
+}
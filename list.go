@@ -0,0 +1,115 @@
+package pgfs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// DirOrder is the order of the entries returned
+// by [FS.ReadDirSorted].
+type DirOrder int
+
+// Orders supported by [FS.ReadDirSorted], which are
+// ascending unless their name ends with Desc.
+const (
+	ByName DirOrder = iota
+	ByNameDesc
+	ByCreatedAt
+	ByCreatedAtDesc
+	BySize
+	BySizeDesc
+	ByContentType
+	ByContentTypeDesc
+)
+
+// dirOrders maps each [DirOrder] to its ORDER BY clause.
+// Ties are broken by name, so pages are stable.
+var dirOrders = map[DirOrder]string{
+	ByName:            "id ASC",
+	ByNameDesc:        "id DESC",
+	ByCreatedAt:       "created_at ASC, id ASC",
+	ByCreatedAtDesc:   "created_at DESC, id ASC",
+	BySize:            "content_size ASC, id ASC",
+	BySizeDesc:        "content_size DESC, id ASC",
+	ByContentType:     "content_type ASC, id ASC",
+	ByContentTypeDesc: "content_type DESC, id ASC",
+}
+
+// ReadDirSorted returns the entries of up to n files in the
+// given order, skipping the first offset ones. A non-positive
+// n returns all the remaining files.
+func (fsys *FS) ReadDirSorted(order DirOrder, n, offset int) ([]fs.DirEntry, error) {
+	orderBy, ok := dirOrders[order]
+	if !ok {
+		return nil, fmt.Errorf("invalid order %d", order)
+	}
+	return fsys.listEntries("TRUE", orderBy, n, offset)
+}
+
+// ReadDirFilter returns the entries of up to n files whose sys
+// contains all the key-value pairs of match, ordered by name and
+// skipping the first offset ones. An empty match lists all files.
+// See [FS.ReadDirSorted] for more details on n.
+func (fsys *FS) ReadDirFilter(match Sys, n, offset int) ([]fs.DirEntry, error) {
+	const where = `COALESCE(sys, '{}'::jsonb) @> COALESCE($3::jsonb, '{}'::jsonb)`
+	return fsys.listEntries(where, dirOrders[ByName], n, offset, match)
+}
+
+// ReadDirByContentType returns the entries of up to n files whose
+// content type starts with prefix, such as "image/" or "image/png",
+// ordered by name and skipping the first offset ones. See
+// [FS.ReadDirSorted] for more details on n.
+func (fsys *FS) ReadDirByContentType(prefix string, n, offset int) ([]fs.DirEntry, error) {
+	const where = `content_type LIKE $3 ESCAPE '\'`
+	return fsys.listEntries(where, dirOrders[ByName], n, offset, likeEscaper.Replace(prefix)+"%")
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ListNonEmpty is like [FS.ReadDir], but leaves out
+// empty files, such as placeholders.
+func (fsys *FS) ListNonEmpty() ([]fs.DirEntry, error) {
+	return fsys.listEntries("content_size > 0", dirOrders[ByName], 0, 0)
+}
+
+// listEntries returns the entries of up to n files matching
+// where, sorted with orderBy, skipping the first offset ones.
+// The arguments of where start at $3.
+//
+// where and orderBy are inserted in the query as is, and
+// must never come from the caller.
+func (fsys *FS) listEntries(where, orderBy string, n, offset int, args ...any) ([]fs.DirEntry, error) {
+	if offset < 0 {
+		return nil, errors.New("invalid offset")
+	}
+
+	// LIMIT NULL returns all rows.
+	var limit any
+	if n > 0 {
+		limit = n
+	}
+
+	q := `
+		SELECT
+			id, oid, created_at,
+			sys, content_size, content_type,
+			content_sha256, meta
+		FROM pgfs_metadata
+		WHERE ` + where + `
+		ORDER BY ` + orderBy + `
+		LIMIT $1 OFFSET $2
+	`
+	infos, err := fsys.queryInfos(q, append([]any{limit, offset}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]fs.DirEntry, len(infos))
+	for i, info := range infos {
+		entries[i] = info.(*entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,346 @@
+package pgfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FileSystem is the interface implemented by [FS], and by the
+// in-memory file system returned by [NewMemFS], so code that
+// depends on it can be tested without a database.
+type FileSystem interface {
+	fs.StatFS
+	fs.ReadFileFS
+	fs.ReadDirFS
+
+	// Create is analog to [FS.Create].
+	Create(name, contentType string, sys map[string]string) (io.WriteCloser, error)
+
+	// Remove is analog to [FS.Remove].
+	Remove(name string) error
+
+	// Exists is analog to [FS.Exists].
+	Exists(name string) (bool, error)
+}
+
+var _ FileSystem = &FS{}
+var _ FileSystem = &memFS{}
+
+// NewMemFS returns an empty [FileSystem] that holds files in
+// memory, and behaves like [FS] otherwise. It's meant to be
+// used as a fake in tests.
+func NewMemFS() FileSystem {
+	return &memFS{files: make(map[uuid.UUID]*memObject)}
+}
+
+// memFS is the [FileSystem] returned by [NewMemFS].
+type memFS struct {
+	mu      sync.RWMutex
+	files   map[uuid.UUID]*memObject
+	lastOID OID
+}
+
+// memObject holds the info and the content of a file.
+type memObject struct {
+	info *entry
+	data []byte
+}
+
+// stat returns a copy of the info of o, so
+// callers can't modify the stored metadata.
+func (o *memObject) stat() *entry {
+	e := *o.info
+	e.sys = cloneSys(o.info.sys)
+	return &e
+}
+
+// cloneSys returns a copy of sys.
+func cloneSys(sys Sys) Sys {
+	if sys == nil {
+		return nil
+	}
+	c := make(Sys, len(sys))
+	for k, v := range sys {
+		c[k] = v
+	}
+	return c
+}
+
+// Open implements [fs.FS].
+func (m *memFS) Open(name string) (fs.File, error) {
+	if IsRoot(name) {
+		info, _ := m.Stat(name)
+		entries, _ := m.ReadDir(name)
+		return &memDir{info: info.(*entry), entries: entries}, nil
+	}
+
+	id, err := uuid.Parse(name)
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	obj, ok := m.files[id]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return &memFile{r: bytes.NewReader(obj.data), info: obj.stat()}, nil
+}
+
+// Stat implements [fs.StatFS].
+func (m *memFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if IsRoot(name) {
+		info := &entry{id: rootUUID, mode: fs.ModeDir}
+		for _, obj := range m.files {
+			info.contentSize += obj.info.contentSize
+			if obj.info.createdAt.After(info.createdAt) {
+				info.createdAt = obj.info.createdAt
+			}
+		}
+		if info.createdAt.IsZero() {
+			info.createdAt = time.Now()
+		}
+		return info, nil
+	}
+
+	id, err := uuid.Parse(name)
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+	obj, ok := m.files[id]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return obj.stat(), nil
+}
+
+// ReadFile implements [fs.ReadFileFS].
+func (m *memFS) ReadFile(name string) ([]byte, error) {
+	f, err := m.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// ReadDir implements [fs.ReadDirFS].
+func (m *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !IsRoot(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entries := make([]fs.DirEntry, 0, len(m.files))
+	for _, obj := range m.files {
+		entries = append(entries, obj.stat())
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// Create implements [FileSystem].
+func (m *memFS) Create(name, contentType string, sys map[string]string) (io.WriteCloser, error) {
+	id, err := uuid.Parse(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: err}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.files[id]; ok {
+		return nil, fs.ErrExist
+	}
+	return &memWriter{fsys: m, id: id, contentType: contentType, sys: cloneSys(sys)}, nil
+}
+
+// Remove implements [FileSystem].
+func (m *memFS) Remove(name string) error {
+	id, err := uuid.Parse(name)
+	if err != nil {
+		return fs.ErrNotExist
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[id]; !ok {
+		return fs.ErrNotExist
+	}
+	delete(m.files, id)
+	return nil
+}
+
+// Exists implements [FileSystem].
+func (m *memFS) Exists(name string) (bool, error) {
+	id, err := uuid.Parse(name)
+	if err != nil {
+		return false, nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.files[id]
+	return ok, nil
+}
+
+// memWriter is the [io.WriteCloser] returned by [memFS.Create].
+type memWriter struct {
+	fsys        *memFS
+	id          uuid.UUID
+	contentType string
+	sys         Sys
+	buf         bytes.Buffer
+	closed      bool
+}
+
+// Write implements [io.Writer].
+func (w *memWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fs.ErrClosed
+	}
+	return w.buf.Write(p)
+}
+
+// Close implements [io.Closer], and stores the file.
+func (w *memWriter) Close() error {
+	if w.closed {
+		return fs.ErrClosed
+	}
+	w.closed = true
+
+	data := w.buf.Bytes()
+	if w.contentType == "" {
+		// Same as writer, which only keeps the first 512 bytes.
+		tag := data
+		if len(tag) > 512 {
+			tag = tag[:512]
+		}
+		w.contentType = detectContentType(tag, len(tag) == len(data))
+	}
+	if strings.TrimSpace(w.contentType) == "" {
+		w.contentType = BinaryType
+	}
+	sum := sha256.Sum256(data)
+
+	w.fsys.mu.Lock()
+	defer w.fsys.mu.Unlock()
+	if _, ok := w.fsys.files[w.id]; ok {
+		return &fs.PathError{Op: "create", Path: w.id.String(), Err: fs.ErrExist}
+	}
+	w.fsys.lastOID++
+	w.fsys.files[w.id] = &memObject{
+		info: &entry{
+			oid:           w.fsys.lastOID,
+			id:            w.id,
+			createdAt:     time.Now(),
+			contentType:   w.contentType,
+			contentSize:   int64(len(data)),
+			contentSHA256: sum[:],
+			sys:           w.sys,
+		},
+		data: data,
+	}
+	return nil
+}
+
+// memFile is the [fs.File] of a file in a [memFS].
+type memFile struct {
+	r      *bytes.Reader
+	info   *entry
+	closed bool
+}
+
+// Stat implements [fs.File].
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// Read implements [io.Reader].
+func (f *memFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	return f.r.Read(p)
+}
+
+// Seek implements [io.Seeker].
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	return f.r.Seek(offset, whence)
+}
+
+// ReadAt implements [io.ReaderAt].
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	return f.r.ReadAt(p, off)
+}
+
+// Close implements [io.Closer].
+func (f *memFile) Close() error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	return nil
+}
+
+// memDir is the [fs.ReadDirFile] of the root of a [memFS].
+type memDir struct {
+	info    *entry
+	entries []fs.DirEntry
+	closed  bool
+}
+
+func (d *memDir) Read(p []byte) (int, error) { return 0, fs.ErrInvalid }
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+
+// Close implements [io.Closer].
+func (d *memDir) Close() error {
+	if d.closed {
+		return fs.ErrClosed
+	}
+	d.closed = true
+	return nil
+}
+
+// ReadDir implements [fs.ReadDirFile].
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+var _ fs.File = &memFile{}
+var _ io.ReadSeeker = &memFile{}
+var _ io.ReaderAt = &memFile{}
+var _ fs.ReadDirFile = &memDir{}
@@ -0,0 +1,90 @@
+package pgfs
+
+import (
+	"fmt"
+	"io/fs"
+	"strconv"
+	"time"
+)
+
+// Reserved [Sys] keys, read by [MetaView].
+const (
+	// FilenameKey holds the original name of a file,
+	// such as "report.pdf".
+	FilenameKey = "filename"
+
+	// EncodingKey holds the content encoding of a file,
+	// such as "gzip".
+	EncodingKey = "encoding"
+
+	// ModTimeKey holds the original modification time of
+	// a file, formatted as [time.RFC3339].
+	//
+	// When present and valid, it's returned by [fs.FileInfo.ModTime]
+	// instead of the time the file was created in the database.
+	ModTimeKey = "mtime"
+
+	// OwnerKey holds an identifier of the owner of a file.
+	OwnerKey = "owner"
+
+	// SourceURLKey holds the URL a file was downloaded from
+	// by [FS.CreateFromURL].
+	SourceURLKey = "source_url"
+
+	// CRC32CKey holds the CRC-32C checksum of the content of
+	// a file, as 8 hexadecimal digits. It's set when the file
+	// is created with [CreateOptions.ComputeCRC32C].
+	CRC32CKey = "crc32c"
+)
+
+// MetaView offers typed accessors to the reserved keys of [Sys].
+// Accessors return zero values for missing keys.
+type MetaView Sys
+
+// Meta returns a [MetaView] on the [Sys] of info. It's
+// empty if info was not returned by this package.
+func Meta(info fs.FileInfo) MetaView {
+	sys, _ := info.Sys().(Sys)
+	return MetaView(sys)
+}
+
+// Filename returns the value of [FilenameKey].
+func (m MetaView) Filename() string { return m[FilenameKey] }
+
+// Encoding returns the value of [EncodingKey].
+func (m MetaView) Encoding() string { return m[EncodingKey] }
+
+// Owner returns the value of [OwnerKey].
+func (m MetaView) Owner() string { return m[OwnerKey] }
+
+// SourceURL returns the value of [SourceURLKey].
+func (m MetaView) SourceURL() string { return m[SourceURLKey] }
+
+// CRC32C returns the checksum held by [CRC32CKey], and
+// whether it's present and valid.
+func (m MetaView) CRC32C() (uint32, bool) {
+	v, ok := m[CRC32CKey]
+	if !ok || len(v) != 8 {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(v, 16, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(n), true
+}
+
+// formatCRC32C formats sum as the value of [CRC32CKey].
+func formatCRC32C(sum uint32) string {
+	return fmt.Sprintf("%08x", sum)
+}
+
+// OriginalModTime returns the time held by [ModTimeKey],
+// or the zero time if it's missing or invalid.
+func (m MetaView) OriginalModTime() time.Time {
+	t, err := time.Parse(time.RFC3339, m[ModTimeKey])
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -1,10 +1,22 @@
 package pgfs
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
 // Table is the name of the metadata table
 // created when [MigrateUp] is called.
 const Table = "pgfs_metadata"
 
 // Up is the SQL query executed by [MigrateUp].
+//
+// Columns and constraints added after the table was first
+// introduced are created if missing, so calling [MigrateUp]
+// also upgrades existing tables. Empty content types stored
+// before the introduction of the check on content_type are
+// replaced with [BinaryType].
 const Up = `
 	CREATE EXTENSION IF NOT EXISTS lo;
 	CREATE TABLE IF NOT EXISTS pgfs_metadata (
@@ -16,21 +28,93 @@ const Up = `
 		content_size BIGINT NOT NULL,
 		content_sha256 BYTEA NOT NULL
 	);
+	ALTER TABLE pgfs_metadata ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP;
+	ALTER TABLE pgfs_metadata ADD COLUMN IF NOT EXISTS meta BYTEA;
+	ALTER TABLE pgfs_metadata ADD COLUMN IF NOT EXISTS last_accessed_at TIMESTAMP;
+	DO $$
+	BEGIN
+		IF NOT EXISTS (
+			SELECT 1 FROM pg_constraint
+			WHERE conname = 'pgfs_metadata_content_type_check'
+		) THEN
+			UPDATE pgfs_metadata SET content_type = 'application/octet-stream' WHERE content_type = '';
+			ALTER TABLE pgfs_metadata
+				ADD CONSTRAINT pgfs_metadata_content_type_check CHECK (content_type <> '');
+		END IF;
+	END $$;
 `
 
+// columns lists the columns of [Table] created by [Up].
+var columns = []string{
+	"id",
+	"oid",
+	"created_at",
+	"sys",
+	"content_type",
+	"content_size",
+	"content_sha256",
+	"updated_at",
+	"meta",
+	"last_accessed_at",
+}
+
+// ErrSchemaOutdated is returned by [FS.CheckSchema] when
+// columns are missing from [Table].
+var ErrSchemaOutdated = errors.New("outdated schema")
+
 // Down is the SQL query executed by [MigrateDown].
 const Down = "DROP TABLE pgfs_metadata;"
 
 // MigrateUp executes the SQL query in [Up].
 //
 // Calling MigrateUp multiple times has no effect.
-func MigrateUp(conn Tx) error {
+func MigrateUp(conn Querier) error {
 	_, err := conn.Exec(Up)
 	return err
 }
 
 // MigrateDown executes the SQL query in [Down].
-func MigrateDown(conn Tx) error {
-	_, err := conn.Exec(Up)
+func MigrateDown(conn Querier) error {
+	_, err := conn.Exec(Down)
 	return err
 }
+
+// CheckSchema returns an error wrapping [ErrSchemaOutdated] and
+// listing the missing columns if [Table] lacks some of the columns
+// used by this version of the package, in which case [MigrateUp]
+// must be called to upgrade it.
+func (fsys *FS) CheckSchema() error {
+	const q = `
+		SELECT column_name
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1
+	`
+	rows, err := fsys.conn.Query(q, Table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	found := make(map[string]bool, len(columns))
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		found[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, c := range columns {
+		if !found[c] {
+			missing = append(missing, c)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s is missing columns %s, call MigrateUp to upgrade it", ErrSchemaOutdated, Table, strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package pgfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/google/uuid"
+)
+
+// File is a file returned by [FS.OpenFile].
+type File interface {
+	fs.File
+	io.Seeker
+	io.Writer
+}
+
+// OpenFile is the generalized open call, analog to [os.OpenFile].
+// Files opened without write flags behave like those returned by
+// [FS.Open].
+//
+// The supported flags are [os.O_RDONLY], [os.O_WRONLY], [os.O_RDWR],
+// [os.O_CREATE], [os.O_EXCL], [os.O_APPEND] and [os.O_TRUNC].
+//
+// If a file is created, contentType and sys are used as in
+// [FS.Create], except that an empty content type is stored
+// as [BinaryType], as there is no content to detect it from.
+//
+// Write modes break the immutability of files: content can be
+// changed in place, and the size and digest stored in the metadata
+// are only updated when the file is closed, by loading the whole
+// content on the server. It must therefore be smaller than 1GB.
+func (fsys *FS) OpenFile(name string, flag int, contentType string, sys Sys) (File, error) {
+	const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_CREATE | os.O_APPEND | os.O_TRUNC
+	if flag&writeFlags == 0 {
+		f, err := fsys.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		ff, ok := f.(*file)
+		if !ok {
+			f.Close()
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+		}
+		return ff, nil
+	}
+
+	if err := fsys.checkWrite("open", name); err != nil {
+		return nil, err
+	}
+	if IsRoot(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	id, err := uuid.Parse(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	ctx := context.Background()
+	if fsys.db != nil {
+		c, tx, err := fsys.begin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		f, err := c.OpenFile(name, flag, contentType, sys)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		f.(*file).tx = tx
+		return f, nil
+	}
+
+	if flag&os.O_CREATE != 0 {
+		if contentType == "" {
+			contentType = BinaryType
+		}
+		_, err := fsys.WriteFile(name, contentType, sys, nil)
+		switch {
+		case err == nil:
+			break
+		case errors.Is(err, fs.ErrExist) && flag&os.O_EXCL == 0:
+			break
+		case errors.Is(err, fs.ErrExist):
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+		default:
+			return nil, err
+		}
+	}
+
+	info, fd, err := open(ctx, fsys.conn, id, invRead|invWrite)
+	if err != nil {
+		return nil, err
+	}
+	f := &file{
+		ctx:  ctx,
+		fd:   fd,
+		fsys: fsys,
+		info: info,
+		flag: flag,
+	}
+	if flag&os.O_TRUNC != 0 {
+		if err := truncate(ctx, fsys.conn, fd, 0); err != nil {
+			f.Close()
+			return nil, err
+		}
+		f.dirty = true
+	}
+	return f, nil
+}
+
+// Write implements [io.Writer] for files opened
+// in a write mode with [FS.OpenFile].
+func (f *file) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return 0, &fs.PathError{Op: "write", Path: f.info.id.String(), Err: fs.ErrPermission}
+	}
+	if f.flag&os.O_APPEND != 0 {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			return 0, err
+		}
+	}
+
+	if !f.stale && f.pos+int64(len(p)) > maxSize {
+		return 0, ErrLargeObjectFull
+	}
+
+	n, err := write(f.ctx, f.fsys.conn, f.fd, p)
+	f.pos += int64(n)
+	if n > 0 {
+		f.dirty = true
+	}
+	if err != nil {
+		f.stale = true
+	}
+	return n, err
+}
+
+// WriteAt implements [io.WriterAt] for files opened in a write
+// mode with [FS.OpenFile], by seeking to off before writing, and
+// seeking back to the current position after. Writing past the
+// end of the content grows the file, and fills the gap with zeros.
+//
+// As with any change to a file, its size and digest are updated
+// when it's closed, which requires the server to read the whole
+// content again, whatever the size of the changes.
+func (f *file) WriteAt(p []byte, off int64) (n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return 0, &fs.PathError{Op: "write", Path: f.info.id.String(), Err: fs.ErrPermission}
+	}
+	if f.flag&os.O_APPEND != 0 {
+		return 0, &fs.PathError{Op: "write", Path: f.info.id.String(), Err: errors.New("invalid use of WriteAt on file opened with O_APPEND")}
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "write", Path: f.info.id.String(), Err: fs.ErrInvalid}
+	}
+	if off+int64(len(p)) > maxSize {
+		return 0, ErrLargeObjectFull
+	}
+
+	pos, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = seek(f.ctx, f.fsys.conn, f.fd, off, io.SeekStart); err != nil {
+		f.stale = true
+		return 0, err
+	}
+	n, err = write(f.ctx, f.fsys.conn, f.fd, p)
+	if n > 0 {
+		f.dirty = true
+	}
+	if _, sErr := seek(f.ctx, f.fsys.conn, f.fd, pos, io.SeekStart); sErr != nil {
+		f.stale = true
+		if err == nil {
+			err = sErr
+		}
+	}
+	return n, err
+}
+
+// Truncate changes the size of a file opened in a write mode
+// with [FS.OpenFile]. If the file grows, it's filled with zeros.
+// The position is left unchanged.
+func (f *file) Truncate(size int64) error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return &fs.PathError{Op: "truncate", Path: f.info.id.String(), Err: fs.ErrPermission}
+	}
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: f.info.id.String(), Err: fs.ErrInvalid}
+	}
+	if err := truncate(f.ctx, f.fsys.conn, f.fd, size); err != nil {
+		return err
+	}
+	f.dirty = true
+	return nil
+}
+
+// Truncate changes the size of the file with the given name,
+// and updates its size and digest. If the file grows, it's
+// filled with zeros.
+//
+// Like [FS.OpenFile] in write modes, it breaks the
+// immutability of files.
+func (fsys *FS) Truncate(name string, size int64) error {
+	f, err := fsys.OpenFile(name, os.O_WRONLY, "", nil)
+	if err != nil {
+		return err
+	}
+	if err := f.(*file).Truncate(size); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// refresh updates the size and the digest of the file
+// with the given id from the content of its large object.
+func (fsys *FS) refresh(ctx context.Context, id uuid.UUID) error {
+	const q = `
+		UPDATE pgfs_metadata
+		SET
+			content_size = length(lo_get(oid)),
+			content_sha256 = sha256(lo_get(oid)),
+			updated_at = NOW()
+		WHERE id = $1
+	`
+	_, err := exec(ctx, fsys.conn, q, id)
+	return mapError(err)
+}
+
+var (
+	_ File        = &file{}
+	_ io.WriterAt = &file{}
+)
@@ -1,26 +1,43 @@
 package pgfs
 
 import (
+	"archive/zip"
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"crypto/sha512"
 	"database/sql"
 	"embed"
 	"encoding/base64"
+	"encoding/binary"
+	"encoding/gob"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"io/fs"
 	"log"
 	"math"
+	"mime"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 	"time"
 
+	"github.com/google/uuid"
 	_ "github.com/jackc/pgx/v5/stdlib" // Postgres driver
 	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 )
 
 var TestDB *sql.DB
@@ -124,12 +141,17 @@ func withFS(t *testing.T, fn func(fsys *FS)) {
 
 func createFile(t *testing.T, fsys *FS, name, contentType string, sys Sys) {
 	t.Helper()
+	createFileBytes(t, fsys, name, contentType, sys, TestBytes)
+}
+
+func createFileBytes(t *testing.T, fsys *FS, name, contentType string, sys Sys, data []byte) {
+	t.Helper()
 
 	w, err := fsys.Create(name, contentType, sys)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if _, err := w.Write(TestBytes); err != nil {
+	if _, err := w.Write(data); err != nil {
 		t.Fatal(err)
 	}
 	if err := w.Close(); err != nil {
@@ -141,6 +163,7 @@ func TestValidPath(t *testing.T) {
 	testCases := map[string]bool{
 		GenerateUUID():          true,
 		"":                      true,
+		".":                     true,
 		"hello":                 false,
 		"12345":                 false,
 		GenerateUUID() + "1234": false,
@@ -755,6 +778,4730 @@ func TestWalkFunc(t *testing.T) {
 	})
 }
 
+func TestFSDescribe(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, "image/png", nil)
+
+		contentType, size, err := fsys.Describe(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wanted := "image/png"; contentType != wanted {
+			t.Error("content types don't match. Wanted:", wanted, "Got:", contentType)
+		}
+		if wanted := int64(len(TestBytes)); size != wanted {
+			t.Error("sizes don't match. Wanted:", wanted, "Got:", size)
+		}
+
+		if _, _, err := fsys.Describe(GenerateUUID()); err != fs.ErrNotExist {
+			t.Error("expected fs.ErrNotExist. Got:", err)
+		}
+	})
+}
+
+func TestFSStatModTimeKey(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		mtime := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, Sys{ModTimeKey: mtime.Format(time.RFC3339)})
+
+		info, err := fsys.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Error("mod times don't match. Wanted:", mtime, "Got:", info.ModTime())
+		}
+
+		invalid := GenerateUUID()
+		createFile(t, fsys, invalid, BinaryType, Sys{ModTimeKey: "yesterday"})
+
+		info, err = fsys.Stat(invalid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.ModTime().IsZero() || info.ModTime().Equal(mtime) {
+			t.Error("expected creation time. Got:", info.ModTime())
+		}
+	})
+}
+
+func TestFSRegisterBatch(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		items := make([]RegisterItem, 3)
+		for i := range items {
+			var oid OID
+			if err := fsys.conn.QueryRow(`SELECT lo_from_bytea(0, $1)`, TestBytes).Scan(&oid); err != nil {
+				t.Fatal(err)
+			}
+			items[i] = RegisterItem{
+				Name:        GenerateUUID(),
+				OID:         oid,
+				ContentType: "image/png",
+				Sys:         Sys{"index": strconv.Itoa(i)},
+			}
+		}
+
+		if err := fsys.RegisterBatch(items); err != nil {
+			t.Fatal(err)
+		}
+
+		for i, item := range items {
+			info, err := fsys.Stat(item.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fi := info.(FileInfo)
+			if fi.OID() != item.OID {
+				t.Error("OIDs don't match. Wanted:", item.OID, "Got:", fi.OID())
+			}
+			if fi.Size() != int64(len(TestBytes)) {
+				t.Error("sizes don't match. Wanted:", len(TestBytes), "Got:", fi.Size())
+			}
+			if !bytes.Equal(fi.ContentSHA256(), TestBytesSHA256) {
+				t.Error("SHA256 digests don't match")
+			}
+			if got := fi.Sys().(Sys)["index"]; got != strconv.Itoa(i) {
+				t.Error("sys doesn't match. Got:", got)
+			}
+
+			b, err := fsys.ReadFile(item.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, TestBytes) {
+				t.Error("bytes don't match")
+			}
+		}
+	})
+}
+
+// cancelingWriter is an [http.ResponseWriter] that
+// cancels a context after the first write.
+type cancelingWriter struct {
+	*httptest.ResponseRecorder
+	cancel context.CancelFunc
+}
+
+func (w *cancelingWriter) Write(p []byte) (int, error) {
+	defer w.cancel()
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestHTTPHandlerCanceled(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		w, err := fsys.Create(name, BinaryType, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size, err := io.Copy(w, io.LimitReader(&loopingReader{src: TestBytes}, 1024<<10)) // 1MB
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := fsys.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		r := httptest.NewRequest(http.MethodGet, "https://example.com", nil).WithContext(ctx)
+		rw := &cancelingWriter{ResponseRecorder: httptest.NewRecorder(), cancel: cancel}
+		ServeFile(rw, r, f)
+
+		if n := int64(rw.Body.Len()); n >= size {
+			t.Fatal("reads did not stop after the request was canceled. Read:", n, "Size:", size)
+		}
+
+		// The file remains usable outside of the request.
+		if _, err := f.(io.Seeker).Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestFSChangedSince(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		for i := 0; i < 3; i++ {
+			createFile(t, fsys, GenerateUUID(), BinaryType, nil)
+		}
+	})
+
+	withFS(t, func(fsys *FS) {
+		// Files created in this transaction share the
+		// value of NOW().
+		var since time.Time
+		if err := fsys.conn.QueryRow(`SELECT NOW()`).Scan(&since); err != nil {
+			t.Fatal(err)
+		}
+
+		wanted := make(map[string]bool)
+		for i := 0; i < 3; i++ {
+			name := GenerateUUID()
+			createFile(t, fsys, name, BinaryType, nil)
+			wanted[name] = true
+		}
+
+		infos, err := fsys.ChangedSince(since.Add(-time.Microsecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(infos) != len(wanted) {
+			t.Fatal("number of files don't match. Wanted:", len(wanted), "Got:", len(infos))
+		}
+		for _, info := range infos {
+			if !wanted[info.Name()] {
+				t.Error("unexpected file:", info.Name())
+			}
+		}
+	})
+}
+
+func TestFSStreamVerified(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, nil)
+
+		t.Run("Intact", func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := fsys.StreamVerified(&buf, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != int64(len(TestBytes)) {
+				t.Error("wrong number of bytes. Wanted:", len(TestBytes), "Got:", n)
+			}
+			if !bytes.Equal(buf.Bytes(), TestBytes) {
+				t.Error("bytes don't match")
+			}
+		})
+
+		t.Run("Corrupted", func(t *testing.T) {
+			info, err := fsys.Stat(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			oid := info.(FileInfo).OID()
+			if _, err := fsys.conn.Exec(`SELECT lo_put($1, 0, '\x00'::bytea)`, oid); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := fsys.StreamVerified(io.Discard, name); err != ErrDigestMismatch {
+				t.Fatal("expected ErrDigestMismatch. Got:", err)
+			}
+		})
+	})
+}
+
+func TestFSCreateLargeObjectFull(t *testing.T) {
+	// The error aborts the transaction, so withFS can't be used.
+	tx, err := TestDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	fsys := New(tx)
+	w, err := fsys.Create(GenerateUUID(), BinaryType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Large objects are sparse, so seeking to the limit
+	// doesn't allocate anything.
+	if _, err := seek(context.Background(), tx, w.(*writer).fd, maxSize, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(TestBytes); err != ErrLargeObjectFull {
+		t.Fatal("expected ErrLargeObjectFull. Got:", err)
+	}
+}
+
+func TestFSLargeObjectFullClient(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		wc, err := fsys.Create(GenerateUUID(), BinaryType, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := wc.(*writer)
+		w.size = maxSize
+		if _, err := w.Write([]byte{0}); err != ErrLargeObjectFull {
+			t.Fatal("expected ErrLargeObjectFull. Got:", err)
+		}
+		if err := w.abort(); err != nil {
+			t.Fatal(err)
+		}
+
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, nil)
+		f, err := fsys.OpenFile(name, os.O_RDWR, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		if _, err := f.(io.WriterAt).WriteAt([]byte{0, 0}, maxSize-1); err != ErrLargeObjectFull {
+			t.Fatal("expected ErrLargeObjectFull on WriteAt. Got:", err)
+		}
+		if _, err := f.Seek(maxSize, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte{0}); err != ErrLargeObjectFull {
+			t.Fatal("expected ErrLargeObjectFull on Write. Got:", err)
+		}
+	})
+}
+
+func TestFSReaderAt(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		fw, err := zw.Create("gopher.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(TestBytes); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		name := GenerateUUID()
+		w, err := fsys.Create(name, "application/zip", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		ra, info, closeFn, err := fsys.ReaderAt(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { closeFn() })
+
+		zr, err := zip.NewReader(ra, info.Size())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(zr.File) != 1 {
+			t.Fatal("wrong number of files in archive. Got:", len(zr.File))
+		}
+
+		rc, err := zr.File[0].Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+
+		b, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, TestBytes) {
+			t.Fatal("bytes don't match")
+		}
+	})
+}
+
+func TestFSReadOnly(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, nil)
+
+		ro := fsys.ReadOnly()
+
+		if _, err := ro.Create(GenerateUUID(), BinaryType, nil); !errors.Is(err, ErrReadOnly) {
+			t.Error("expected ErrReadOnly on Create. Got:", err)
+		}
+		if err := ro.Remove(name); !errors.Is(err, ErrReadOnly) {
+			t.Error("expected ErrReadOnly on Remove. Got:", err)
+		}
+		if err := ro.Register(GenerateUUID(), 1, BinaryType, nil); !errors.Is(err, ErrReadOnly) {
+			t.Error("expected ErrReadOnly on Register. Got:", err)
+		}
+
+		b, err := ro.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, TestBytes) {
+			t.Error("bytes don't match")
+		}
+
+		// Access isn't tracked, so files can be opened
+		// in a read-only transaction.
+		if _, err := fsys.conn.Exec(`SET TRANSACTION READ ONLY`); err != nil {
+			t.Fatal(err)
+		}
+		for _, tracked := range []*FS{ro.TrackAccess(), fsys.TrackAccess().ReadOnly()} {
+			f, err := tracked.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+		}
+	})
+}
+
+func TestFSFindBySize(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		names := make(map[int]string)
+		for _, size := range []int{0, 10, 20, 30} {
+			name := GenerateUUID()
+			createFileBytes(t, fsys, name, BinaryType, nil, TestBytes[:size])
+			names[size] = name
+		}
+
+		testCases := []struct {
+			min, max int64
+			in, out  []int
+		}{
+			{min: 0, max: 15, in: []int{0, 10}, out: []int{20, 30}},
+			{min: 10, max: 20, in: []int{10, 20}, out: []int{0, 30}},
+			{min: 15, max: -1, in: []int{20, 30}, out: []int{0, 10}},
+		}
+		for _, tc := range testCases {
+			infos, err := fsys.FindBySize(tc.min, tc.max)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := make(map[string]bool)
+			for _, info := range infos {
+				if info.Size() < tc.min || (tc.max >= 0 && info.Size() > tc.max) {
+					t.Error("size out of range", tc.min, tc.max, "Got:", info.Size())
+				}
+				found[info.Name()] = true
+			}
+			for _, size := range tc.in {
+				if !found[names[size]] {
+					t.Error("missing file of size", size, "in range", tc.min, tc.max)
+				}
+			}
+			for _, size := range tc.out {
+				if found[names[size]] {
+					t.Error("unexpected file of size", size, "in range", tc.min, tc.max)
+				}
+			}
+		}
+	})
+}
+
+func TestFSOIDFor(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, nil)
+
+		oid, err := fsys.OIDFor(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := fsys.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wanted := info.(FileInfo).OID(); oid != wanted {
+			t.Error("OIDs don't match. Wanted:", wanted, "Got:", oid)
+		}
+
+		if _, err := fsys.OIDFor(GenerateUUID()); err != fs.ErrNotExist {
+			t.Error("expected fs.ErrNotExist. Got:", err)
+		}
+	})
+}
+
+func TestFSCreateSized(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		size := int64(len(TestBytes))
+
+		t.Run("Exact", func(t *testing.T) {
+			name := GenerateUUID()
+			info, err := fsys.CreateSized(name, "image/png", size, nil, bytes.NewReader(TestBytes))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != size {
+				t.Error("sizes don't match. Wanted:", size, "Got:", info.Size())
+			}
+			if !bytes.Equal(info.ContentSHA256(), TestBytesSHA256) {
+				t.Error("SHA256 digests don't match")
+			}
+		})
+
+		testCases := map[string]int64{
+			"Short": size + 1,
+			"Long":  size - 1,
+		}
+		for label, announced := range testCases {
+			t.Run(label, func(t *testing.T) {
+				name := GenerateUUID()
+				_, err := fsys.CreateSized(name, "image/png", announced, nil, bytes.NewReader(TestBytes))
+				if !errors.Is(err, ErrSizeMismatch) {
+					t.Fatal("expected ErrSizeMismatch. Got:", err)
+				}
+				if _, err := fsys.Stat(name); err != fs.ErrNotExist {
+					t.Fatal("expected fs.ErrNotExist. Got:", err)
+				}
+			})
+		}
+	})
+}
+
+func TestFSIncrSys(t *testing.T) {
+	name := GenerateUUID()
+	withFS(t, func(fsys *FS) {
+		createFile(t, fsys, name, BinaryType, Sys{"title": "gopher"})
+	})
+
+	const (
+		key     = "downloads"
+		workers = 10
+	)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			tx, err := TestDB.Begin()
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer tx.Rollback()
+
+			if _, err := New(tx).IncrSys(name, key, 1); err != nil {
+				errs <- err
+				return
+			}
+			errs <- tx.Commit()
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < workers; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	withFS(t, func(fsys *FS) {
+		n, err := fsys.IncrSys(name, key, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != workers {
+			t.Fatal("wrong counter value. Wanted:", workers, "Got:", n)
+		}
+
+		info, err := fsys.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sys := info.Sys().(Sys)
+		if sys[key] != strconv.Itoa(workers) || sys["title"] != "gopher" {
+			t.Error("unexpected sys:", sys)
+		}
+
+		if _, err := fsys.IncrSys(GenerateUUID(), key, 1); err != fs.ErrNotExist {
+			t.Error("expected fs.ErrNotExist. Got:", err)
+		}
+	})
+}
+
+func TestFSEachPage(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		wanted := make(map[string]bool)
+		for i := 0; i < 25; i++ {
+			name := GenerateUUID()
+			createFile(t, fsys, name, BinaryType, nil)
+			wanted[name] = true
+		}
+
+		const pageSize = 10
+		seen := make(map[string]bool)
+		err := fsys.EachPage(pageSize, func(infos []FileInfo) error {
+			if len(infos) > pageSize {
+				t.Error("page is too large:", len(infos))
+			}
+			for _, info := range infos {
+				if seen[info.Name()] {
+					t.Error("file seen twice:", info.Name())
+				}
+				seen[info.Name()] = true
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name := range wanted {
+			if !seen[name] {
+				t.Error("file not seen:", name)
+			}
+		}
+
+		errStop := errors.New("stop")
+		calls := 0
+		err = fsys.EachPage(pageSize, func(infos []FileInfo) error {
+			calls++
+			return errStop
+		})
+		if err != errStop {
+			t.Error("expected errStop. Got:", err)
+		}
+		if calls != 1 {
+			t.Error("iteration did not stop. Calls:", calls)
+		}
+	})
+}
+
+func TestReprDigest(t *testing.T) {
+	sha256Digest := sha256.Sum256(TestBytes)
+	sha512Digest := sha512.Sum512(TestBytes)
+
+	testCases := []struct {
+		algo   string
+		digest []byte
+	}{
+		{"sha-256", sha256Digest[:]},
+		{"sha-512", sha512Digest[:]},
+	}
+	for _, tc := range testCases {
+		wanted := tc.algo + "=:" + base64.StdEncoding.EncodeToString(tc.digest) + ":"
+		if got := ReprDigest(tc.algo, tc.digest); got != wanted {
+			t.Error("Wanted:", wanted, "Got:", got)
+		}
+	}
+}
+
+func TestParseReprDigest(t *testing.T) {
+	sha256Digest := sha256.Sum256(TestBytes)
+	sha512Digest := sha512.Sum512(TestBytes)
+
+	t.Run("Valid", func(t *testing.T) {
+		testCases := map[string]struct {
+			algo   string
+			digest []byte
+		}{
+			ReprDigest("sha-256", sha256Digest[:]):                                                 {"sha-256", sha256Digest[:]},
+			ReprDigest("sha-512", sha512Digest[:]):                                                 {"sha-512", sha512Digest[:]},
+			ReprDigest("sha-512", sha512Digest[:]) + ", " + ReprDigest("sha-256", sha256Digest[:]): {"sha-256", sha256Digest[:]},
+			ReprDigest("md5", []byte("1234")) + ", " + ReprDigest("sha-256", sha256Digest[:]):      {"sha-256", sha256Digest[:]},
+		}
+		for header, wanted := range testCases {
+			algo, digest, err := ParseReprDigest(header)
+			if err != nil {
+				t.Error(header, err)
+				continue
+			}
+			if algo != wanted.algo || !bytes.Equal(digest, wanted.digest) {
+				t.Error("Header:", header, "Wanted:", wanted.algo, "Got:", algo)
+			}
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		_, _, err := ParseReprDigest(ReprDigest("md5", []byte("1234")))
+		if err != ErrUnsupportedDigest {
+			t.Fatal("expected ErrUnsupportedDigest. Got:", err)
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		testCases := []string{
+			"",
+			"garbage",
+			"sha-256=abc",
+			"sha-256=:not base64!:",
+			ReprDigest("sha-256", []byte("too short")),
+		}
+		for _, header := range testCases {
+			_, _, err := ParseReprDigest(header)
+			if err == nil || err == ErrUnsupportedDigest {
+				t.Error("expected a parsing error for", header, "Got:", err)
+			}
+		}
+	})
+}
+
+func TestIsRoot(t *testing.T) {
+	testCases := map[string]bool{
+		"":             true,
+		".":            true,
+		root:           false,
+		GenerateUUID(): false,
+	}
+
+	for name, wanted := range testCases {
+		if got := IsRoot(name); wanted != got {
+			t.Error("Name:", name, "Wanted:", wanted, "Got:", got)
+		}
+	}
+}
+
+func TestHTTPHandlerIfRange(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, "image/png", nil)
+
+		f, err := fsys.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+
+		etag := `"` + hex.EncodeToString(TestBytesSHA256) + `"`
+		testCases := map[string]struct {
+			ifRange string
+			status  int
+			body    []byte
+		}{
+			"Matching":     {etag, http.StatusPartialContent, TestBytes[:10]},
+			"Not matching": {`"stale"`, http.StatusOK, TestBytes},
+		}
+		for label, tc := range testCases {
+			t.Run(label, func(t *testing.T) {
+				r := httptest.NewRequest(http.MethodGet, "https://example.com", nil)
+				r.Header.Set("Range", "bytes=0-9")
+				r.Header.Set("If-Range", tc.ifRange)
+				w := httptest.NewRecorder()
+				f.(http.Handler).ServeHTTP(w, r)
+
+				resp := w.Result()
+				if resp.StatusCode != tc.status {
+					t.Fatal("Wanted:", tc.status, "Got:", resp.StatusCode)
+				}
+				if !bytes.Equal(w.Body.Bytes(), tc.body) {
+					t.Fatal("bytes don't match. Wanted:", len(tc.body), "Got:", w.Body.Len())
+				}
+			})
+		}
+	})
+}
+
+func TestFSSniffContentType(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, "application/pdf", nil)
+
+		got, err := fsys.SniffContentType(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wanted := "image/png"; got != wanted {
+			t.Fatal("Wanted:", wanted, "Got:", got)
+		}
+
+		contentType, _, err := fsys.Describe(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType != "application/pdf" {
+			t.Fatal("stored content type was modified:", contentType)
+		}
+	})
+}
+
+func BenchmarkFSCreateConcurrent(b *testing.B) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		tx, err := TestDB.Begin()
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		defer tx.Rollback()
+
+		fsys := New(tx)
+		for pb.Next() {
+			w, err := fsys.Create(GenerateUUID(), "", nil)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			if _, err := w.Write(TestBytes); err != nil {
+				b.Error(err)
+				return
+			}
+			if err := w.Close(); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func TestFSExportImportLO(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		var allowed bool
+		const q = `
+			SELECT rolsuper OR (
+				pg_has_role(current_user, 'pg_read_server_files', 'MEMBER') AND
+				pg_has_role(current_user, 'pg_write_server_files', 'MEMBER')
+			)
+			FROM pg_roles
+			WHERE rolname = current_user
+		`
+		if err := fsys.conn.QueryRow(q).Scan(&allowed); err != nil {
+			t.Fatal(err)
+		}
+		if !allowed {
+			t.Skip("role is not allowed to access the server's file system")
+		}
+
+		src := GenerateUUID()
+		createFile(t, fsys, src, "image/png", nil)
+
+		serverPath := "/tmp/pgfs-" + GenerateUUID()
+		if err := fsys.ExportLO(src, serverPath); err != nil {
+			t.Fatal(err)
+		}
+
+		dst := GenerateUUID()
+		info, err := fsys.ImportLO(dst, serverPath, "image/png", Sys{"source": src})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(len(TestBytes)) {
+			t.Error("sizes don't match. Wanted:", len(TestBytes), "Got:", info.Size())
+		}
+		if !bytes.Equal(info.ContentSHA256(), TestBytesSHA256) {
+			t.Error("SHA256 digests don't match")
+		}
+
+		b, err := fsys.ReadFile(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, TestBytes) {
+			t.Error("bytes don't match")
+		}
+
+		// Failed imports leave no large object behind.
+		before, err := fsys.LargeObjectCount()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fsys.ImportLO(dst, serverPath, "image/png", nil); !errors.Is(err, fs.ErrExist) {
+			t.Fatal("Wanted:", fs.ErrExist, "Got:", err)
+		}
+		if _, err := fsys.ImportLO("not-a-uuid", serverPath, "image/png", nil); err == nil {
+			t.Fatal("invalid name was accepted")
+		}
+		after, err := fsys.LargeObjectCount()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after != before {
+			t.Fatal("Wanted:", before, "large objects, Got:", after)
+		}
+	})
+}
+
+func TestFSFilter(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		images := make(map[string]bool)
+		others := make(map[string]bool)
+		for i := 0; i < 5; i++ {
+			name := GenerateUUID()
+			createFile(t, fsys, name, "image/png", nil)
+			images[name] = true
+
+			name = GenerateUUID()
+			createFile(t, fsys, name, "application/pdf", nil)
+			others[name] = true
+		}
+
+		ffs := fsys.Filter(func(info FileInfo) bool {
+			return strings.HasPrefix(info.ContentType(), "image/")
+		})
+
+		seen := make(map[string]bool)
+		err := fs.WalkDir(ffs, "", func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if ct := info.(FileInfo).ContentType(); !strings.HasPrefix(ct, "image/") {
+				t.Error("unexpected content type:", ct)
+			}
+			seen[path] = true
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name := range images {
+			if !seen[name] {
+				t.Error("image not seen:", name)
+			}
+		}
+
+		for name := range others {
+			if _, err := fs.Stat(ffs, name); err != fs.ErrNotExist {
+				t.Error("expected fs.ErrNotExist. Got:", err)
+			}
+			if _, err := ffs.Open(name); err != fs.ErrNotExist {
+				t.Error("expected fs.ErrNotExist. Got:", err)
+			}
+		}
+
+		for name := range images {
+			b, err := fs.ReadFile(ffs, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, TestBytes) {
+				t.Error("bytes don't match")
+			}
+			break
+		}
+	})
+}
+
+func TestMeta(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		mtime := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+		name := GenerateUUID()
+		createFile(t, fsys, name, "image/png", Sys{
+			FilenameKey: "gopher.png",
+			EncodingKey: "identity",
+			ModTimeKey:  mtime.Format(time.RFC3339),
+			OwnerKey:    "renee",
+		})
+
+		info, err := fsys.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := Meta(info)
+		if got := m.Filename(); got != "gopher.png" {
+			t.Error("wrong filename:", got)
+		}
+		if got := m.Encoding(); got != "identity" {
+			t.Error("wrong encoding:", got)
+		}
+		if got := m.Owner(); got != "renee" {
+			t.Error("wrong owner:", got)
+		}
+		if got := m.OriginalModTime(); !got.Equal(mtime) {
+			t.Error("wrong mod time:", got)
+		}
+
+		empty := GenerateUUID()
+		createFile(t, fsys, empty, "image/png", nil)
+
+		info, err = fsys.Stat(empty)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m = Meta(info)
+		if m.Filename() != "" || m.Encoding() != "" || m.Owner() != "" || !m.OriginalModTime().IsZero() {
+			t.Error("expected zero values. Got:", m)
+		}
+	})
+}
+
+func TestFSConcatTo(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		lines := []string{"first", "second", "third"}
+		names := make([]string, len(lines))
+		for i, line := range lines {
+			names[i] = GenerateUUID()
+			createFileBytes(t, fsys, names[i], "text/plain", nil, []byte(line))
+		}
+
+		var buf bytes.Buffer
+		n, err := fsys.ConcatTo(&buf, names, []byte("\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wanted := strings.Join(lines, "\n")
+		if buf.String() != wanted {
+			t.Error("Wanted:", wanted, "Got:", buf.String())
+		}
+		if n != int64(len(wanted)) {
+			t.Error("wrong count. Wanted:", len(wanted), "Got:", n)
+		}
+
+		buf.Reset()
+		_, err = fsys.ConcatTo(&buf, append(names, GenerateUUID()), []byte("\n"))
+		if err != fs.ErrNotExist {
+			t.Error("expected fs.ErrNotExist. Got:", err)
+		}
+		if buf.Len() != 0 {
+			t.Error("bytes were written before the error")
+		}
+	})
+}
+
+func TestFSSupports64BitSeek(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		ok, err := fsys.Supports64BitSeek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatal("expected 64-bit seeks to be supported")
+		}
+	})
+}
+
+func TestHTTPHandlerLastUpdated(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, "image/png", nil)
+
+		// Files created and updated in the same transaction
+		// share the value of NOW().
+		const q = `UPDATE pgfs_metadata SET created_at = created_at - interval '1 day' WHERE id = $1`
+		if _, err := fsys.conn.Exec(q, name); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fsys.IncrSys(name, "version", 1); err != nil {
+			t.Fatal(err)
+		}
+
+		var createdAt, updatedAt time.Time
+		err := fsys.conn.QueryRow(`SELECT created_at, updated_at FROM pgfs_metadata WHERE id = $1`, name).Scan(&createdAt, &updatedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testCases := map[string]struct {
+			fsys   *FS
+			wanted time.Time
+		}{
+			"Default":      {fsys, createdAt},
+			"Last updated": {fsys.LastUpdated(), updatedAt},
+		}
+		for label, tc := range testCases {
+			t.Run(label, func(t *testing.T) {
+				f, err := tc.fsys.Open(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+
+				r := httptest.NewRequest(http.MethodGet, "https://example.com", nil)
+				w := httptest.NewRecorder()
+				ServeFile(w, r, f)
+
+				wanted := tc.wanted.UTC().Format(http.TimeFormat)
+				if got := w.Result().Header.Get("Last-Modified"); got != wanted {
+					t.Error("Wanted:", wanted, "Got:", got)
+				}
+			})
+		}
+	})
+}
+
+func TestFSOpenPrefix(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		prefix := GenerateUUID()[:8]
+		names := []string{
+			prefix + GenerateUUID()[8:],
+			prefix + GenerateUUID()[8:],
+		}
+		for _, name := range names {
+			createFile(t, fsys, name, BinaryType, nil)
+		}
+
+		t.Run("Unique", func(t *testing.T) {
+			f, err := fsys.OpenPrefix(strings.ToUpper(names[0][:20]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			info, err := f.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Name() != names[0] {
+				t.Error("Wanted:", names[0], "Got:", info.Name())
+			}
+		})
+
+		t.Run("Ambiguous", func(t *testing.T) {
+			if _, err := fsys.OpenPrefix(prefix); !errors.Is(err, ErrAmbiguousPrefix) {
+				t.Fatal("expected ErrAmbiguousPrefix. Got:", err)
+			}
+		})
+
+		t.Run("Missing", func(t *testing.T) {
+			for _, prefix := range []string{GenerateUUID()[:13], "%", "_"} {
+				if _, err := fsys.OpenPrefix(prefix); err != fs.ErrNotExist {
+					t.Error("expected fs.ErrNotExist for", prefix, "Got:", err)
+				}
+			}
+		})
+	})
+}
+
+func TestHTTPHandlerStableLastModified(t *testing.T) {
+	mtime := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	lastModified := func(t *testing.T, fsys *FS, name string) string {
+		t.Helper()
+
+		f, err := fsys.StableLastModified().Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		r := httptest.NewRequest(http.MethodGet, "https://example.com", nil)
+		w := httptest.NewRecorder()
+		ServeFile(w, r, f)
+		return w.Result().Header.Get("Last-Modified")
+	}
+
+	var a, b, c string
+	withFS(t, func(fsys *FS) {
+		a = GenerateUUID()
+		createFile(t, fsys, a, "image/png", nil)
+		c = GenerateUUID()
+		createFile(t, fsys, c, "image/png", Sys{ModTimeKey: mtime.Format(time.RFC3339)})
+	})
+
+	// Restored in another transaction.
+	withFS(t, func(fsys *FS) {
+		b = GenerateUUID()
+		createFile(t, fsys, b, "image/png", nil)
+		const q = `UPDATE pgfs_metadata SET created_at = created_at + interval '1 day' WHERE id = $1`
+		if _, err := fsys.conn.Exec(q, b); err != nil {
+			t.Fatal(err)
+		}
+
+		if la, lb := lastModified(t, fsys, a), lastModified(t, fsys, b); la != lb {
+			t.Error("Last-Modified headers don't match:", la, lb)
+		}
+		if wanted, got := mtime.Format(http.TimeFormat), lastModified(t, fsys, c); wanted != got {
+			t.Error("Wanted:", wanted, "Got:", got)
+		}
+	})
+}
+
+// failingCloseTx is a [Tx] that fails to close
+// large objects, and counts metadata inserts.
+type failingCloseTx struct {
+	*sql.Tx
+	inserts int
+}
+
+func (tx *failingCloseTx) rewrite(query string) string {
+	if query == `SELECT lo_close($1)` {
+		query = `SELECT -1 + 0 * $1::int`
+	}
+	return query
+}
+
+func (tx *failingCloseTx) count(query string) {
+	if strings.Contains(query, "INSERT INTO pgfs_metadata") {
+		tx.inserts++
+	}
+}
+
+func (tx *failingCloseTx) QueryRow(query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRow(tx.rewrite(query), args...)
+}
+
+func (tx *failingCloseTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRowContext(ctx, tx.rewrite(query), args...)
+}
+
+func (tx *failingCloseTx) Exec(query string, args ...any) (sql.Result, error) {
+	tx.count(query)
+	return tx.Tx.Exec(query, args...)
+}
+
+func (tx *failingCloseTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	tx.count(query)
+	return tx.Tx.ExecContext(ctx, query, args...)
+}
+
+func TestFSCreateCloseError(t *testing.T) {
+	sqlTx, err := TestDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlTx.Rollback()
+
+	tx := &failingCloseTx{Tx: sqlTx}
+	fsys := New(tx)
+
+	name := GenerateUUID()
+	w, err := fsys.Create(name, BinaryType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(TestBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if err := w.Close(); err != fs.ErrClosed {
+		t.Fatal("expected fs.ErrClosed. Got:", err)
+	}
+	if tx.inserts != 0 {
+		t.Fatal("metadata was inserted", tx.inserts, "times")
+	}
+
+	if _, err := fsys.Stat(name); err != fs.ErrNotExist {
+		t.Fatal("expected fs.ErrNotExist. Got:", err)
+	}
+
+	var exists bool
+	const q = `SELECT EXISTS(SELECT 1 FROM pg_largeobject_metadata WHERE oid = $1)`
+	if err := sqlTx.QueryRow(q, w.(*writer).oid).Scan(&exists); err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("large object was not deleted")
+	}
+}
+
+func TestFileCloseTwice(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, nil)
+
+		f, err := fsys.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(f); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != fs.ErrClosed {
+			t.Fatal("expected fs.ErrClosed. Got:", err)
+		}
+	})
+}
+
+func TestFileReadClosed(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, nil)
+
+		ra, _, closeFn, err := fsys.ReaderAt(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := fsys.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := closeFn(); err != nil {
+			t.Fatal(err)
+		}
+
+		p := make([]byte, 10)
+		if _, err := f.Read(p); err != fs.ErrClosed {
+			t.Error("expected fs.ErrClosed on Read. Got:", err)
+		}
+		if _, err := f.(io.Seeker).Seek(0, io.SeekStart); err != fs.ErrClosed {
+			t.Error("expected fs.ErrClosed on Seek. Got:", err)
+		}
+		if _, err := ra.ReadAt(p, 0); err != fs.ErrClosed {
+			t.Error("expected fs.ErrClosed on ReadAt. Got:", err)
+		}
+	})
+}
+
+func TestFSCloseOnEOF(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, nil)
+
+		f, err := fsys.CloseOnEOF().Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, TestBytes) {
+			t.Fatal("bytes don't match")
+		}
+
+		if !f.(*file).closed {
+			t.Fatal("descriptor was not released")
+		}
+		if _, err := f.Read(make([]byte, 10)); err != fs.ErrClosed {
+			t.Error("expected fs.ErrClosed. Got:", err)
+		}
+		if err := f.Close(); err != fs.ErrClosed {
+			t.Error("expected fs.ErrClosed. Got:", err)
+		}
+	})
+}
+
+func TestFSLargeObjectCount(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		createFile(t, fsys, GenerateUUID(), BinaryType, nil)
+
+		n, err := fsys.LargeObjectCount()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var files int64
+		if err := fsys.conn.QueryRow(`SELECT COUNT(*) FROM pgfs_metadata`).Scan(&files); err != nil {
+			t.Fatal(err)
+		}
+		if n < files {
+			t.Fatal("fewer large objects than files. Large objects:", n, "Files:", files)
+		}
+	})
+}
+
+func TestFSCreateFromURL(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gopher.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(TestBytes)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	withFS(t, func(fsys *FS) {
+		url := srv.URL + "/gopher.png"
+		name := GenerateUUID()
+		info, err := fsys.CreateFromURL(context.Background(), name, url, "", srv.Client())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.ContentType() != "image/png" {
+			t.Error("wrong content type:", info.ContentType())
+		}
+		if !bytes.Equal(info.ContentSHA256(), TestBytesSHA256) {
+			t.Error("SHA256 digests don't match")
+		}
+		if got := Meta(info).SourceURL(); got != url {
+			t.Error("wrong source URL:", got)
+		}
+
+		overridden := GenerateUUID()
+		info, err = fsys.CreateFromURL(context.Background(), overridden, url, "image/x-gopher", srv.Client())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.ContentType() != "image/x-gopher" {
+			t.Error("wrong content type:", info.ContentType())
+		}
+
+		missing := GenerateUUID()
+		if _, err := fsys.CreateFromURL(context.Background(), missing, srv.URL+"/missing", "", srv.Client()); err == nil {
+			t.Fatal("expected an error")
+		}
+		if _, err := fsys.Stat(missing); err != fs.ErrNotExist {
+			t.Error("expected fs.ErrNotExist. Got:", err)
+		}
+	})
+}
+
+func TestFSMatchETag(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, nil)
+
+		etag := `"` + hex.EncodeToString(TestBytesSHA256) + `"`
+		testCases := map[string]bool{
+			etag:                       true,
+			"W/" + etag:                true,
+			strings.ToUpper(etag):      true,
+			`"` + GenerateUUID() + `"`: false,
+			`"abcd"`:                   false,
+			"garbage":                  false,
+			"":                         false,
+		}
+		for etag, wanted := range testCases {
+			got, err := fsys.MatchETag(name, etag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != wanted {
+				t.Error("ETag:", etag, "Wanted:", wanted, "Got:", got)
+			}
+		}
+
+		if _, err := fsys.MatchETag(GenerateUUID(), etag); err != fs.ErrNotExist {
+			t.Error("expected fs.ErrNotExist. Got:", err)
+		}
+	})
+}
+
+func TestFSSizeHistogram(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		buckets := []int64{1, 10, 50}
+
+		before, err := fsys.SizeHistogram(buckets)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, size := range []int{0, 5, 10, 100} {
+			createFileBytes(t, fsys, GenerateUUID(), BinaryType, nil, TestBytes[:size])
+		}
+
+		after, err := fsys.SizeHistogram(buckets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(after) != len(buckets)+1 {
+			t.Fatal("wrong number of counts:", len(after))
+		}
+		for i := range after {
+			if diff := after[i] - before[i]; diff != 1 {
+				t.Error("bucket", i, "Wanted: 1", "Got:", diff)
+			}
+		}
+
+		if _, err := fsys.SizeHistogram([]int64{10, 1}); err == nil {
+			t.Error("expected an error for unsorted buckets")
+		}
+	})
+}
+
+func TestFSCreateTemp(t *testing.T) {
+	loExists := func(t *testing.T, fsys *FS, oid OID) bool {
+		t.Helper()
+
+		var exists bool
+		const q = `SELECT EXISTS(SELECT 1 FROM pg_largeobject_metadata WHERE oid = $1)`
+		if err := fsys.conn.QueryRow(q, oid).Scan(&exists); err != nil {
+			t.Fatal(err)
+		}
+		return exists
+	}
+
+	withFS(t, func(fsys *FS) {
+		t.Run("Publish", func(t *testing.T) {
+			tmp, err := fsys.CreateTemp("image/png", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tmp.Write(TestBytes); err != nil {
+				t.Fatal(err)
+			}
+
+			existing := GenerateUUID()
+			createFile(t, fsys, existing, BinaryType, nil)
+			if err := tmp.Publish(existing); !errors.Is(err, fs.ErrExist) {
+				t.Fatal("expected fs.ErrExist. Got:", err)
+			}
+
+			name := GenerateUUID()
+			if err := tmp.Publish(name); err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := fsys.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, TestBytes) {
+				t.Error("bytes don't match")
+			}
+			if !loExists(t, fsys, tmp.w.oid) {
+				t.Error("large object was deleted")
+			}
+		})
+
+		t.Run("Discard", func(t *testing.T) {
+			tmp, err := fsys.CreateTemp("image/png", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tmp.Write(TestBytes); err != nil {
+				t.Fatal(err)
+			}
+			if err := tmp.Discard(); err != nil {
+				t.Fatal(err)
+			}
+			if loExists(t, fsys, tmp.w.oid) {
+				t.Error("large object was not deleted")
+			}
+			if err := tmp.Publish(GenerateUUID()); err != fs.ErrClosed {
+				t.Error("expected fs.ErrClosed. Got:", err)
+			}
+		})
+	})
+}
+
+func TestFSCreateRawMeta(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		var meta bytes.Buffer
+		if err := gob.NewEncoder(&meta).Encode(map[string]int{"width": 300, "height": 400}); err != nil {
+			t.Fatal(err)
+		}
+
+		name := GenerateUUID()
+		w, err := fsys.CreateWithOptions(name, CreateOptions{
+			ContentType: "image/png",
+			RawMeta:     meta.Bytes(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(TestBytes); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := fsys.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw := info.(FileInfo).RawMeta()
+		if !bytes.Equal(raw, meta.Bytes()) {
+			t.Fatal("raw metadata doesn't match")
+		}
+
+		var decoded map[string]int
+		if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded["width"] != 300 || decoded["height"] != 400 {
+			t.Error("unexpected metadata:", decoded)
+		}
+
+		other := GenerateUUID()
+		createFile(t, fsys, other, BinaryType, nil)
+		info, err = fsys.Stat(other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if raw := info.(FileInfo).RawMeta(); raw != nil {
+			t.Error("expected nil raw metadata. Got:", raw)
+		}
+	})
+}
+
+func TestFSOpenLines(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		lines := []string{"first", "", "third line", strings.Repeat("x", 100)}
+		name := GenerateUUID()
+		createFileBytes(t, fsys, name, "text/plain", nil, []byte(strings.Join(lines, "\n")+"\n"))
+
+		scanner, closeFn, err := fsys.OpenLines(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer closeFn()
+
+		got := make([]string, 0)
+		for scanner.Scan() {
+			got = append(got, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, "|") != strings.Join(lines, "|") {
+			t.Fatal("lines don't match. Wanted:", lines, "Got:", got)
+		}
+
+		// Lines longer than the buffer are reported as errors.
+		scanner, closeFn2, err := fsys.OpenLines(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer closeFn2()
+		scanner.Buffer(make([]byte, 0, 16), 16)
+		for scanner.Scan() {
+		}
+		if err := scanner.Err(); err != bufio.ErrTooLong {
+			t.Fatal("expected bufio.ErrTooLong. Got:", err)
+		}
+	})
+}
+
+// slowReadTx is a [Tx] that sleeps before reading
+// from large objects.
+type slowReadTx struct {
+	*sql.Tx
+}
+
+func (tx *slowReadTx) slow(query string) string {
+	if query == `SELECT loread($1, $2)` {
+		query = `SELECT loread($1, $2) FROM pg_sleep(0.5)`
+	}
+	return query
+}
+
+func (tx *slowReadTx) QueryRow(query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRow(tx.slow(query), args...)
+}
+
+func (tx *slowReadTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRowContext(ctx, tx.slow(query), args...)
+}
+
+func TestFileReadTimeout(t *testing.T) {
+	name := GenerateUUID()
+	withFS(t, func(fsys *FS) {
+		createFile(t, fsys, name, BinaryType, nil)
+	})
+
+	sqlTx, err := TestDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlTx.Rollback()
+
+	fsys := New(&slowReadTx{Tx: sqlTx})
+	f, err := fsys.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sqlTx.Exec(`SET LOCAL statement_timeout = '50ms'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Read(make([]byte, 512)); !errors.Is(err, ErrTimeout) {
+		t.Fatal("expected ErrTimeout. Got:", err)
+	}
+}
+
+func TestFSSetContentTypeFromExtension(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, Sys{FilenameKey: "report.pdf"})
+
+		contentType, err := fsys.SetContentTypeFromExtension(name, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wanted := "application/pdf"; contentType != wanted {
+			t.Error("Wanted:", wanted, "Got:", contentType)
+		}
+		if got, _, _ := fsys.Describe(name); got != "application/pdf" {
+			t.Error("content type was not stored. Got:", got)
+		}
+
+		if _, err := fsys.SetContentTypeFromExtension(name, "unknown.ext-that-does-not-exist"); err == nil {
+			t.Error("expected an error for an unknown extension")
+		}
+		if _, err := fsys.SetContentTypeFromExtension(GenerateUUID(), "report.pdf"); err != fs.ErrNotExist {
+			t.Error("expected fs.ErrNotExist. Got:", err)
+		}
+	})
+}
+
+func TestFSSetContentTypesFromExtensions(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		pdf := GenerateUUID()
+		createFile(t, fsys, pdf, BinaryType, Sys{FilenameKey: "report.pdf"})
+		png := GenerateUUID()
+		createFile(t, fsys, png, BinaryType, Sys{FilenameKey: "gopher.png"})
+		none := GenerateUUID()
+		createFile(t, fsys, none, BinaryType, nil)
+
+		n, err := fsys.SetContentTypesFromExtensions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n < 2 {
+			t.Error("expected at least 2 updates. Got:", n)
+		}
+
+		testCases := map[string]string{
+			pdf:  "application/pdf",
+			png:  "image/png",
+			none: BinaryType,
+		}
+		for name, wanted := range testCases {
+			if got, _, _ := fsys.Describe(name); got != wanted {
+				t.Error("Wanted:", wanted, "Got:", got)
+			}
+		}
+	})
+}
+
+func TestMigrateDown(t *testing.T) {
+	// Migrations are transactional, so the table is
+	// restored when the transaction is rolled back.
+	tx, err := TestDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	if err := MigrateUp(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MigrateDown(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	var dropped bool
+	if err := tx.QueryRow(`SELECT to_regclass('pgfs_metadata') IS NULL`).Scan(&dropped); err != nil {
+		t.Fatal(err)
+	}
+	if !dropped {
+		t.Fatal("table was not dropped")
+	}
+}
+
+func TestFSOpenCached(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, nil)
+
+		const maxCacheBytes = 10000
+		rsc, err := fsys.OpenCached(name, maxCacheBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rsc.Close()
+
+		ref := bytes.NewReader(TestBytes)
+		size := int64(len(TestBytes))
+		steps := []struct {
+			offset int64
+			whence int
+			n      int
+		}{
+			{0, io.SeekStart, 100},
+			{50, io.SeekStart, 200},
+			{-150, io.SeekCurrent, 25000},
+			{-300, io.SeekEnd, 1000},
+			{9990, io.SeekStart, 20},
+			{0, io.SeekStart, len(TestBytes)},
+			{size / 2, io.SeekStart, 10},
+			{-5, io.SeekCurrent, 30000},
+		}
+		for i, step := range steps {
+			wanted, err := ref.Seek(step.offset, step.whence)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := rsc.Seek(step.offset, step.whence)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != wanted {
+				t.Fatal("step", i, "positions don't match. Wanted:", wanted, "Got:", got)
+			}
+
+			wb := make([]byte, step.n)
+			wn, _ := io.ReadFull(ref, wb)
+			gb := make([]byte, step.n)
+			gn, _ := io.ReadFull(rsc, gb)
+			if !bytes.Equal(wb[:wn], gb[:gn]) {
+				t.Fatal("step", i, "bytes don't match")
+			}
+		}
+
+		c := rsc.(*cachedFile)
+		if c.cached > maxCacheBytes {
+			t.Error("cache is larger than allowed:", c.cached)
+		}
+	})
+}
+
+func TestFSReadDirName(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		createFile(t, fsys, GenerateUUID(), BinaryType, nil)
+
+		_, err := fsys.ReadDir("something")
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal("wanted", fs.ErrNotExist, "got", err)
+		}
+		var pathErr *fs.PathError
+		if !errors.As(err, &pathErr) || pathErr.Path != "something" {
+			t.Fatal("wanted a *fs.PathError, got", err)
+		}
+
+		for _, name := range []string{"", "."} {
+			entries, err := fsys.ReadDir(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) == 0 {
+				t.Fatal("no entries listed for", strconv.Quote(name))
+			}
+		}
+	})
+}
+
+func TestFSDuplicates(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		content := []byte("duplicate " + GenerateUUID())
+		first, second := GenerateUUID(), GenerateUUID()
+		createFileBytes(t, fsys, first, BinaryType, nil, content)
+		createFileBytes(t, fsys, second, BinaryType, nil, content)
+		createFileBytes(t, fsys, GenerateUUID(), BinaryType, nil, []byte("unique "+GenerateUUID()))
+
+		dups, err := fsys.Duplicates()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		digest := sha256.Sum256(content)
+		names := dups[hex.EncodeToString(digest[:])]
+		if len(names) != 2 {
+			t.Fatal("Wanted 2 duplicates. Got:", names)
+		}
+		for _, name := range []string{first, second} {
+			if !slices.Contains(names, name) {
+				t.Error(name, "missing from", names)
+			}
+		}
+		for digest, names := range dups {
+			if len(names) < 2 {
+				t.Error(digest, "isn't duplicated:", names)
+			}
+		}
+	})
+}
+
+func TestFileReadSmallBuffer(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, nil)
+
+		f, err := fsys.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		var (
+			total int
+			h     = sha256.New()
+			buf   = make([]byte, 1000)
+		)
+		for {
+			n, err := f.Read(buf)
+			total += n
+			h.Write(buf[:n])
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n == 0 {
+				t.Fatal("read returned no bytes and no error")
+			}
+		}
+
+		if total != len(TestBytes) {
+			t.Fatal("Wanted:", len(TestBytes), "Got:", total)
+		}
+		if !bytes.Equal(h.Sum(nil), TestBytesSHA256[:]) {
+			t.Fatal("digests don't match")
+		}
+	})
+}
+
+func TestFSOIDHeadroom(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		createFile(t, fsys, GenerateUUID(), BinaryType, nil)
+
+		used, estimatedMax, err := fsys.OIDHeadroom()
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := fsys.LargeObjectCount()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if used != n {
+			t.Error("Wanted:", n, "Got:", used)
+		}
+		if estimatedMax <= used || estimatedMax > math.MaxUint32 {
+			t.Error("unexpected maximum:", estimatedMax)
+		}
+	})
+}
+
+// countingTx is a [Tx] that counts the queries
+// containing match.
+type countingTx struct {
+	*sql.Tx
+	match string
+	n     int
+}
+
+func (tx *countingTx) count(query string) {
+	if strings.Contains(query, tx.match) {
+		tx.n++
+	}
+}
+
+func (tx *countingTx) QueryRow(query string, args ...any) *sql.Row {
+	tx.count(query)
+	return tx.Tx.QueryRow(query, args...)
+}
+
+func (tx *countingTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	tx.count(query)
+	return tx.Tx.QueryRowContext(ctx, query, args...)
+}
+
+func TestFileSeekCurrent(t *testing.T) {
+	sqlTx, err := TestDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlTx.Rollback()
+
+	tx := &countingTx{Tx: sqlTx, match: "lo_lseek64"}
+	fsys := New(tx)
+
+	name := GenerateUUID()
+	createFile(t, fsys, name, BinaryType, nil)
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rs := f.(io.ReadSeeker)
+
+	if _, err := io.ReadFull(rs, make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.Seek(50, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(rs, make([]byte, 25)); err != nil {
+		t.Fatal(err)
+	}
+
+	seeks := tx.n
+	pos, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != 75 {
+		t.Fatal("Wanted: 75", "Got:", pos)
+	}
+	if tx.n != seeks {
+		t.Fatal("a query was issued to get the current offset")
+	}
+
+	// Make sure the cached position matches the server's.
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, rs); err != nil {
+		t.Fatal(err)
+	}
+	pos, err = rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	end, err := seek(context.Background(), tx, f.(*file).fd, 0, io.SeekCurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != end || pos != int64(len(TestBytes)) {
+		t.Fatal("positions don't match. Cached:", pos, "Server:", end)
+	}
+}
+
+func TestFSServeName(t *testing.T) {
+	sqlTx, err := TestDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlTx.Rollback()
+
+	tx := &countingTx{Tx: sqlTx, match: "lo_open("}
+	fsys := New(tx)
+
+	name := GenerateUUID()
+	createFile(t, fsys, name, "image/png", nil)
+	etag := `"` + hex.EncodeToString(TestBytesSHA256) + `"`
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com", nil)
+	w := httptest.NewRecorder()
+	fsys.ServeName(w, r, name)
+	if w.Code != http.StatusOK {
+		t.Fatal("Wanted:", http.StatusOK, "Got:", w.Code)
+	}
+	if !bytes.Equal(w.Body.Bytes(), TestBytes) {
+		t.Fatal("bytes don't match")
+	}
+	if w.Header().Get("ETag") != etag {
+		t.Fatal("Wanted:", etag, "Got:", w.Header().Get("ETag"))
+	}
+	lastModified := w.Header().Get("Last-Modified")
+
+	for _, header := range []struct{ key, value string }{
+		{"If-None-Match", etag},
+		{"If-None-Match", "W/" + etag},
+		{"If-Modified-Since", lastModified},
+	} {
+		tx.n = 0
+		r := httptest.NewRequest(http.MethodGet, "https://example.com", nil)
+		r.Header.Set(header.key, header.value)
+		w := httptest.NewRecorder()
+		fsys.ServeName(w, r, name)
+		if w.Code != http.StatusNotModified {
+			t.Fatal(header.key, "Wanted:", http.StatusNotModified, "Got:", w.Code)
+		}
+		if tx.n != 0 {
+			t.Fatal(header.key, "large object was opened", tx.n, "times")
+		}
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "https://example.com", nil)
+	r.Header.Set("If-None-Match", `"stale"`)
+	w = httptest.NewRecorder()
+	fsys.ServeName(w, r, name)
+	if w.Code != http.StatusOK {
+		t.Fatal("Wanted:", http.StatusOK, "Got:", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	fsys.ServeName(w, httptest.NewRequest(http.MethodGet, "https://example.com", nil), GenerateUUID())
+	if w.Code != http.StatusNotFound {
+		t.Fatal("Wanted:", http.StatusNotFound, "Got:", w.Code)
+	}
+}
+
+func TestWriterLargeBuffer(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		b := make([]byte, 8<<20)
+		if _, err := io.ReadFull(&loopingReader{src: TestBytes}, b); err != nil {
+			t.Fatal(err)
+		}
+
+		name := GenerateUUID()
+		w, err := fsys.Create(name, BinaryType, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := w.Write(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(b) {
+			t.Fatal("Wanted:", len(b), "Got:", n)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := fsys.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(len(b)) {
+			t.Fatal("Wanted:", len(b), "Got:", info.Size())
+		}
+		digest := sha256.Sum256(b)
+		if !bytes.Equal(info.(FileInfo).ContentSHA256(), digest[:]) {
+			t.Fatal("digests don't match")
+		}
+	})
+}
+
+func TestFSBroadcast(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, nil)
+
+		buf := &bytes.Buffer{}
+		h := sha256.New()
+		n, err := fsys.Broadcast(name, buf, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(TestBytes)) {
+			t.Fatal("Wanted:", len(TestBytes), "Got:", n)
+		}
+		if !bytes.Equal(buf.Bytes(), TestBytes) {
+			t.Fatal("bytes don't match")
+		}
+		if !bytes.Equal(h.Sum(nil), TestBytesSHA256) {
+			t.Fatal("digests don't match")
+		}
+
+		errWrite := errors.New("write error")
+		if _, err := fsys.Broadcast(name, &bytes.Buffer{}, failingWriter{errWrite}); err != errWrite {
+			t.Fatal("Wanted:", errWrite, "Got:", err)
+		}
+
+		if _, err := fsys.Broadcast(GenerateUUID(), buf); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal("Wanted:", fs.ErrNotExist, "Got:", err)
+		}
+	})
+}
+
+// failingWriter is an [io.Writer] that always fails.
+type failingWriter struct {
+	err error
+}
+
+func (w failingWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
+
+func TestFSContext(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		w, err := fsys.CreateContext(context.Background(), name, BinaryType, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.CopyN(w, &loopingReader{src: TestBytes}, 4<<20); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := fsys.StatContext(context.Background(), name); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fsys.ReadDirContext(context.Background(), ""); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		f, err := fsys.OpenContext(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		if _, err := io.ReadFull(f, make([]byte, 1<<20)); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		if _, err := f.Read(make([]byte, 1<<20)); !errors.Is(err, context.Canceled) {
+			t.Fatal("Wanted:", context.Canceled, "Got:", err)
+		}
+		if _, err := fsys.ReadFileContext(ctx, name); !errors.Is(err, context.Canceled) {
+			t.Fatal("Wanted:", context.Canceled, "Got:", err)
+		}
+		if err := fsys.RemoveContext(ctx, name); !errors.Is(err, context.Canceled) {
+			t.Fatal("Wanted:", context.Canceled, "Got:", err)
+		}
+
+		if err := fsys.RemoveContext(context.Background(), name); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestFSCreateCRC32C(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		sys := Sys{"key": "value"}
+		w, err := fsys.CreateWithOptions(name, CreateOptions{
+			ContentType:   BinaryType,
+			Sys:           sys,
+			ComputeCRC32C: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(TestBytes); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := sys[CRC32CKey]; ok {
+			t.Fatal("sys of the caller was modified")
+		}
+
+		info, err := fsys.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum, ok := info.(FileInfo).CRC32C()
+		if !ok {
+			t.Fatal("missing checksum")
+		}
+		if wanted := crc32.Checksum(TestBytes, crc32.MakeTable(crc32.Castagnoli)); sum != wanted {
+			t.Fatal("Wanted:", wanted, "Got:", sum)
+		}
+		if Meta(info)["key"] != "value" {
+			t.Fatal("sys was not stored")
+		}
+
+		other := GenerateUUID()
+		createFile(t, fsys, other, BinaryType, nil)
+		info, err = fsys.Stat(other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := info.(FileInfo).CRC32C(); ok {
+			t.Fatal("unexpected checksum")
+		}
+	})
+}
+
+// cancelingReader is an [io.Reader] that calls cancel
+// once n bytes have been read from r.
+type cancelingReader struct {
+	r      io.Reader
+	n      int64
+	cancel context.CancelFunc
+}
+
+func (r *cancelingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if r.n -= int64(n); r.n <= 0 {
+		r.cancel()
+	}
+	return n, err
+}
+
+func TestFSCreateLargeFileCancel(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		w, err := fsys.CreateContext(ctx, name, BinaryType, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := &cancelingReader{
+			r:      io.LimitReader(&loopingReader{src: TestBytes}, 100*1024<<10), // 100MB
+			n:      10 * 1024 << 10,
+			cancel: cancel,
+		}
+		written, err := io.Copy(w, r)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatal("Wanted:", context.Canceled, "Got:", err)
+		}
+		if written >= 100*1024<<10 {
+			t.Fatal("the copy was not interrupted")
+		}
+		if err := w.Close(); err == nil {
+			t.Fatal("expected an error closing the writer")
+		}
+		if _, err := fsys.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal("Wanted:", fs.ErrNotExist, "Got:", err)
+		}
+
+		createFile(t, fsys, name, BinaryType, nil)
+		ctx, cancel = context.WithCancel(context.Background())
+		defer cancel()
+		f, err := fsys.OpenContext(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = &cancelingReader{r: f, n: 1000, cancel: cancel}
+		if _, err := io.Copy(io.Discard, r); !errors.Is(err, context.Canceled) {
+			t.Fatal("Wanted:", context.Canceled, "Got:", err)
+		}
+		if err := f.Close(); !errors.Is(err, context.Canceled) {
+			t.Fatal("Wanted:", context.Canceled, "Got:", err)
+		}
+	})
+}
+
+func TestFSRecentlyAccessed(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		names := make([]string, 3)
+		for i := range names {
+			names[i] = GenerateUUID()
+			createFile(t, fsys, names[i], BinaryType, nil)
+		}
+
+		// Files opened without tracking are not listed.
+		f, err := fsys.Open(names[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		infos, err := fsys.RecentlyAccessed(100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, info := range infos {
+			if info.Name() == names[0] {
+				t.Fatal("access was tracked")
+			}
+		}
+
+		tracked := fsys.TrackAccess()
+		for _, i := range []int{1, 0, 2} {
+			f, err := tracked.Open(names[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+		}
+
+		infos, err = fsys.RecentlyAccessed(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(infos) != 3 {
+			t.Fatal("Wanted: 3", "Got:", len(infos))
+		}
+		for i, wanted := range []string{names[2], names[0], names[1]} {
+			if infos[i].Name() != wanted {
+				t.Error(i, "Wanted:", wanted, "Got:", infos[i].Name())
+			}
+		}
+	})
+}
+
+func TestNewDB(t *testing.T) {
+	fsys := NewDB(TestDB)
+
+	name := GenerateUUID()
+	w, err := fsys.Create(name, "image/png", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(TestBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is not visible before the writer is closed.
+	if _, err := fsys.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("Wanted:", fs.ErrNotExist, "Got:", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := fsys.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(TestBytes)) {
+		t.Fatal("Wanted:", len(TestBytes), "Got:", info.Size())
+	}
+
+	b, err := fsys.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, TestBytes) {
+		t.Fatal("bytes don't match")
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.(io.Seeker).Seek(10, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fsys.CreateSized(GenerateUUID(), BinaryType, 10, nil, bytes.NewReader(TestBytes)); !errors.Is(err, ErrSizeMismatch) {
+		t.Fatal("Wanted:", ErrSizeMismatch, "Got:", err)
+	}
+
+	if err := fsys.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("Wanted:", fs.ErrNotExist, "Got:", err)
+	}
+}
+
+// queryOnly is a [Querier] that only exposes
+// the query methods of a transaction.
+type queryOnly struct {
+	tx *sql.Tx
+}
+
+func (q queryOnly) Query(query string, args ...any) (*sql.Rows, error) {
+	return q.tx.Query(query, args...)
+}
+
+func (q queryOnly) QueryRow(query string, args ...any) *sql.Row {
+	return q.tx.QueryRow(query, args...)
+}
+
+func (q queryOnly) Exec(query string, args ...any) (sql.Result, error) {
+	return q.tx.Exec(query, args...)
+}
+
+func TestNewQuerier(t *testing.T) {
+	tx, err := TestDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	var conn Querier = queryOnly{tx: tx}
+	if _, ok := conn.(Tx); ok {
+		t.Fatal("queryOnly must not implement Tx")
+	}
+	fsys := New(conn)
+
+	name := GenerateUUID()
+	createFile(t, fsys, name, BinaryType, nil)
+
+	b, err := fsys.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, TestBytes) {
+		t.Fatal("bytes don't match")
+	}
+	if err := fsys.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFSSetSysWhere(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		batch := GenerateUUID()
+		tagged := []string{GenerateUUID(), GenerateUUID()}
+		for _, name := range tagged {
+			createFile(t, fsys, name, BinaryType, Sys{"batch": batch, "key": "old"})
+		}
+		other := GenerateUUID()
+		createFile(t, fsys, other, BinaryType, Sys{"batch": GenerateUUID()})
+
+		updated, err := fsys.SetSysWhere("batch", batch, Sys{"tag": "reviewed", "key": "new"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated != len(tagged) {
+			t.Fatal("Wanted:", len(tagged), "Got:", updated)
+		}
+
+		for _, name := range tagged {
+			info, err := fsys.Stat(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sys := Meta(info)
+			if sys["tag"] != "reviewed" || sys["key"] != "new" || sys["batch"] != batch {
+				t.Error("unexpected sys:", sys)
+			}
+		}
+
+		info, err := fsys.Stat(other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := Meta(info)["tag"]; ok {
+			t.Error("file not matching the filter was updated")
+		}
+
+		if _, err := fsys.ReadOnly().SetSysWhere("batch", batch, Sys{"tag": "x"}); !errors.Is(err, ErrReadOnly) {
+			t.Error("Wanted:", ErrReadOnly, "Got:", err)
+		}
+	})
+}
+
+func TestServeFileSeeker(t *testing.T) {
+	f, err := TestFS.Open("testing/gopher.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com", nil)
+	r.Header.Set("Range", "bytes=10-19")
+	w := httptest.NewRecorder()
+	ServeFile(w, r, f)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatal("Wanted:", http.StatusPartialContent, "Got:", w.Code)
+	}
+	if !bytes.Equal(w.Body.Bytes(), TestBytes[10:20]) {
+		t.Fatal("bytes don't match")
+	}
+}
+
+func TestFSCopy(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		src := GenerateUUID()
+		w, err := fsys.Create(src, "image/png", Sys{"key": "value"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Larger than a chunk, and not a multiple of it.
+		if _, err := io.CopyN(w, &loopingReader{src: TestBytes}, 3*copyChunkSize+123); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		dst := GenerateUUID()
+		dstInfo, err := fsys.Copy(src, dst, Sys{"copy": "true"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		srcInfo, err := fsys.Stat(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if dstInfo.Size() != srcInfo.Size() {
+			t.Fatal("sizes don't match")
+		}
+		if !bytes.Equal(dstInfo.ContentSHA256(), srcInfo.(FileInfo).ContentSHA256()) {
+			t.Fatal("digests don't match")
+		}
+		if dstInfo.OID() == srcInfo.(FileInfo).OID() {
+			t.Fatal("OIDs should be different")
+		}
+		if dstInfo.ContentType() != "image/png" {
+			t.Fatal("Wanted: image/png", "Got:", dstInfo.ContentType())
+		}
+		if sys := Meta(dstInfo); sys["copy"] != "true" || sys["key"] != "" {
+			t.Fatal("unexpected sys:", sys)
+		}
+
+		if _, err := fsys.StreamVerified(io.Discard, dst); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := fsys.Copy(src, dst, nil); !errors.Is(err, fs.ErrExist) {
+			t.Fatal("Wanted:", fs.ErrExist, "Got:", err)
+		}
+		if _, err := fsys.Copy(GenerateUUID(), GenerateUUID(), nil); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal("Wanted:", fs.ErrNotExist, "Got:", err)
+		}
+	})
+}
+
+func TestFSDataURI(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, "image/png", nil)
+
+		uri, err := fsys.DataURILimit(64 << 10).DataURI(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contentType, data, ok := strings.Cut(strings.TrimPrefix(uri, "data:"), ";base64,")
+		if !ok {
+			t.Fatal("malformed URI:", uri[:32])
+		}
+		if contentType != "image/png" {
+			t.Fatal("Wanted: image/png", "Got:", contentType)
+		}
+		b, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, TestBytes) {
+			t.Fatal("bytes don't match")
+		}
+
+		// The test file is larger than the default limit.
+		if _, err := fsys.DataURI(name); !errors.Is(err, ErrFileTooLarge) {
+			t.Fatal("Wanted:", ErrFileTooLarge, "Got:", err)
+		}
+		if _, err := fsys.DataURI(GenerateUUID()); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal("Wanted:", fs.ErrNotExist, "Got:", err)
+		}
+	})
+}
+
+func TestFSSetSys(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, Sys{"caption": "old", "credit": "someone"})
+
+		stat := func() Sys {
+			t.Helper()
+			info, err := fsys.Stat(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return info.Sys().(Sys)
+		}
+
+		if err := fsys.SetSys(name, Sys{"caption": "new"}); err != nil {
+			t.Fatal(err)
+		}
+		if sys := stat(); len(sys) != 1 || sys["caption"] != "new" {
+			t.Fatal("unexpected sys:", sys)
+		}
+
+		if err := fsys.MergeSys(name, Sys{"credit": "someone else"}); err != nil {
+			t.Fatal(err)
+		}
+		if sys := stat(); len(sys) != 2 || sys["caption"] != "new" || sys["credit"] != "someone else" {
+			t.Fatal("unexpected sys:", sys)
+		}
+
+		if err := fsys.SetSys(name, nil); err != nil {
+			t.Fatal(err)
+		}
+		if sys := stat(); len(sys) != 0 {
+			t.Fatal("unexpected sys:", sys)
+		}
+
+		for _, err := range []error{
+			fsys.SetSys(GenerateUUID(), Sys{}),
+			fsys.MergeSys(GenerateUUID(), Sys{}),
+		} {
+			if !errors.Is(err, fs.ErrNotExist) {
+				t.Error("Wanted:", fs.ErrNotExist, "Got:", err)
+			}
+		}
+		if err := fsys.ReadOnly().SetSys(name, nil); !errors.Is(err, ErrReadOnly) {
+			t.Error("Wanted:", ErrReadOnly, "Got:", err)
+		}
+	})
+}
+
+func TestFSBatch(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		removed, updated := GenerateUUID(), GenerateUUID()
+		createFile(t, fsys, removed, BinaryType, nil)
+		createFile(t, fsys, updated, BinaryType, Sys{"key": "old"})
+
+		exists := func(name string) bool {
+			t.Helper()
+			_, err := fsys.Stat(name)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				t.Fatal(err)
+			}
+			return err == nil
+		}
+
+		// The last operation fails, so none is applied.
+		created := GenerateUUID()
+		results, err := fsys.Batch().
+			Create(created, BinaryType, nil, bytes.NewReader(TestBytes)).
+			Remove(removed).
+			SetSys(updated, Sys{"key": "new"}).
+			SetSys(GenerateUUID(), Sys{"key": "new"}).
+			Apply()
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal("Wanted:", fs.ErrNotExist, "Got:", err)
+		}
+		if len(results) != 4 {
+			t.Fatal("Wanted: 4 results", "Got:", len(results))
+		}
+		for i, r := range results[:3] {
+			if r.Err != nil {
+				t.Error(i, r.Op, r.Name, r.Err)
+			}
+		}
+		if exists(created) || !exists(removed) {
+			t.Fatal("operations were not rolled back")
+		}
+		info, err := fsys.Stat(updated)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Meta(info)["key"] != "old" {
+			t.Fatal("sys was not rolled back")
+		}
+
+		results, err = fsys.Batch().
+			Create(created, BinaryType, nil, bytes.NewReader(TestBytes)).
+			Remove(removed).
+			SetSys(updated, Sys{"key": "new"}).
+			Apply()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 3 {
+			t.Fatal("Wanted: 3 results", "Got:", len(results))
+		}
+		if !exists(created) || exists(removed) {
+			t.Fatal("operations were not applied")
+		}
+		info, err = fsys.Stat(updated)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Meta(info)["key"] != "new" {
+			t.Fatal("sys was not updated")
+		}
+	})
+}
+
+func TestFSWriteFile(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		info, err := fsys.WriteFile(name, "", Sys{"key": "value"}, TestBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Name() != name || info.Size() != int64(len(TestBytes)) {
+			t.Fatal("unexpected info:", info.Name(), info.Size())
+		}
+		if info.ContentType() != "image/png" {
+			t.Fatal("Wanted: image/png", "Got:", info.ContentType())
+		}
+		if Meta(info)["key"] != "value" {
+			t.Fatal("sys was not stored")
+		}
+
+		b, err := fsys.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, TestBytes) {
+			t.Fatal("bytes don't match")
+		}
+
+		if _, err := fsys.WriteFile(name, BinaryType, nil, TestBytes); !errors.Is(err, fs.ErrExist) {
+			t.Fatal("Wanted:", fs.ErrExist, "Got:", err)
+		}
+	})
+}
+
+func TestFSTx(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, "image/png", nil)
+
+		const q = `
+			SELECT m.content_size
+			FROM pgfs_metadata m
+			JOIN (VALUES ($1::uuid)) AS v (id) ON v.id = m.id
+		`
+		var size int64
+		if err := fsys.Tx().QueryRow(q, name).Scan(&size); err != nil {
+			t.Fatal(err)
+		}
+		if size != int64(len(TestBytes)) {
+			t.Fatal("Wanted:", len(TestBytes), "Got:", size)
+		}
+	})
+}
+
+func TestFSCreateFrom(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		const size = 5 << 20
+		h := sha256.New()
+		r := io.TeeReader(io.LimitReader(&loopingReader{src: TestBytes}, size), h)
+
+		name := GenerateUUID()
+		info, err := fsys.CreateFrom(name, "", nil, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != size {
+			t.Fatal("Wanted:", size, "Got:", info.Size())
+		}
+		if !bytes.Equal(info.ContentSHA256(), h.Sum(nil)) {
+			t.Fatal("digests don't match")
+		}
+		if info.ContentType() != "image/png" {
+			t.Fatal("Wanted: image/png", "Got:", info.ContentType())
+		}
+
+		errRead := errors.New("read error")
+		other := GenerateUUID()
+		if _, err := fsys.CreateFrom(other, BinaryType, nil, io.MultiReader(bytes.NewReader(TestBytes), iotest.ErrReader(errRead))); err != errRead {
+			t.Fatal("Wanted:", errRead, "Got:", err)
+		}
+		if _, err := fsys.Stat(other); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal("Wanted:", fs.ErrNotExist, "Got:", err)
+		}
+	})
+}
+
+func TestFSCheckSchema(t *testing.T) {
+	tx, err := TestDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	fsys := New(tx)
+	if err := fsys.CheckSchema(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Drop the columns added after the table was first introduced.
+	const q = `ALTER TABLE pgfs_metadata DROP COLUMN meta, DROP COLUMN last_accessed_at`
+	if _, err := tx.Exec(q); err != nil {
+		t.Fatal(err)
+	}
+	err = fsys.CheckSchema()
+	if !errors.Is(err, ErrSchemaOutdated) {
+		t.Fatal("Wanted:", ErrSchemaOutdated, "Got:", err)
+	}
+	for _, c := range []string{"meta", "last_accessed_at"} {
+		if !strings.Contains(err.Error(), c) {
+			t.Error(c, "is not reported as missing:", err)
+		}
+	}
+	if strings.Contains(err.Error(), "updated_at") {
+		t.Error("updated_at is reported as missing:", err)
+	}
+
+	if err := MigrateUp(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.CheckSchema(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFSOpenFull(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, nil)
+
+		full, err := fsys.OpenFull(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer full.Close()
+		f, err := fsys.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		streaming := f.(io.ReadSeeker)
+
+		for i, step := range []struct {
+			offset int64
+			whence int
+			n      int
+		}{
+			{0, io.SeekStart, 100},
+			{-50, io.SeekCurrent, 1000},
+			{-200, io.SeekEnd, 500},
+			{1234, io.SeekStart, len(TestBytes)},
+		} {
+			wanted, err := streaming.Seek(step.offset, step.whence)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := full.Seek(step.offset, step.whence)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != wanted {
+				t.Fatal("step", i, "positions don't match. Wanted:", wanted, "Got:", got)
+			}
+
+			wb := make([]byte, step.n)
+			wn, _ := io.ReadFull(streaming, wb)
+			gb := make([]byte, step.n)
+			gn, _ := io.ReadFull(full, gb)
+			if !bytes.Equal(wb[:wn], gb[:gn]) {
+				t.Fatal("step", i, "bytes don't match")
+			}
+		}
+
+		if _, err := fsys.OpenFullLimit(1024).OpenFull(name); !errors.Is(err, ErrFileTooLarge) {
+			t.Fatal("Wanted:", ErrFileTooLarge, "Got:", err)
+		}
+		if _, err := fsys.OpenFull(GenerateUUID()); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal("Wanted:", fs.ErrNotExist, "Got:", err)
+		}
+	})
+}
+
+func TestFSOpenFile(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		check := func(t *testing.T, name string, wanted []byte) {
+			t.Helper()
+			b, err := fsys.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, wanted) {
+				t.Fatal("bytes don't match")
+			}
+			info, err := fsys.Stat(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != int64(len(wanted)) {
+				t.Fatal("Wanted:", len(wanted), "Got:", info.Size())
+			}
+			digest := sha256.Sum256(wanted)
+			if !bytes.Equal(info.(FileInfo).ContentSHA256(), digest[:]) {
+				t.Fatal("digests don't match")
+			}
+		}
+
+		t.Run("append", func(t *testing.T) {
+			name := GenerateUUID()
+			createFile(t, fsys, name, BinaryType, nil)
+
+			f, err := fsys.OpenFile(name, os.O_WRONLY|os.O_APPEND, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Writes go to the end regardless of the position.
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Write([]byte("tail")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Read(make([]byte, 10)); !errors.Is(err, fs.ErrPermission) {
+				t.Fatal("Wanted:", fs.ErrPermission, "Got:", err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			check(t, name, append(append([]byte{}, TestBytes...), "tail"...))
+		})
+
+		t.Run("truncate", func(t *testing.T) {
+			name := GenerateUUID()
+			createFile(t, fsys, name, BinaryType, nil)
+
+			f, err := fsys.OpenFile(name, os.O_RDWR|os.O_TRUNC, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Write([]byte("new content")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Seek(4, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "content" {
+				t.Fatal("Wanted: content", "Got:", string(b))
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			check(t, name, []byte("new content"))
+		})
+
+		t.Run("create", func(t *testing.T) {
+			name := GenerateUUID()
+			f, err := fsys.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, "", Sys{"key": "value"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Write(TestBytes); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			check(t, name, TestBytes)
+
+			if _, err := fsys.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, "", nil); !errors.Is(err, fs.ErrExist) {
+				t.Fatal("Wanted:", fs.ErrExist, "Got:", err)
+			}
+		})
+
+		t.Run("read-only", func(t *testing.T) {
+			name := GenerateUUID()
+			createFile(t, fsys, name, BinaryType, nil)
+
+			f, err := fsys.OpenFile(name, os.O_RDONLY, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if _, err := f.Write([]byte("x")); !errors.Is(err, fs.ErrPermission) {
+				t.Fatal("Wanted:", fs.ErrPermission, "Got:", err)
+			}
+			if _, err := fsys.ReadOnly().OpenFile(name, os.O_RDWR, "", nil); !errors.Is(err, ErrReadOnly) {
+				t.Fatal("Wanted:", ErrReadOnly, "Got:", err)
+			}
+		})
+	})
+}
+
+func TestFSTruncate(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, nil)
+
+		for _, size := range []int64{100, 0, 10} {
+			if err := fsys.Truncate(name, size); err != nil {
+				t.Fatal(err)
+			}
+
+			wanted := make([]byte, size)
+			if size == 100 {
+				copy(wanted, TestBytes)
+			}
+			info, err := fsys.Stat(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != size {
+				t.Fatal("Wanted:", size, "Got:", info.Size())
+			}
+			digest := sha256.Sum256(wanted)
+			if !bytes.Equal(info.(FileInfo).ContentSHA256(), digest[:]) {
+				t.Fatal("digests don't match for size", size)
+			}
+			b, err := fsys.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, wanted) {
+				t.Fatal("bytes don't match for size", size)
+			}
+		}
+
+		if err := fsys.Truncate(name, -1); !errors.Is(err, fs.ErrInvalid) {
+			t.Fatal("Wanted:", fs.ErrInvalid, "Got:", err)
+		}
+		if err := fsys.Truncate(GenerateUUID(), 0); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal("Wanted:", fs.ErrNotExist, "Got:", err)
+		}
+	})
+}
+
+func TestFSContentTypeCheck(t *testing.T) {
+	tx, err := TestDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	fsys := New(tx)
+
+	name := GenerateUUID()
+	createFile(t, fsys, name, " ", nil)
+	info, err := fsys.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := info.(FileInfo).ContentType(); ct != BinaryType {
+		t.Fatal("Wanted:", BinaryType, "Got:", ct)
+	}
+
+	const q = `UPDATE pgfs_metadata SET content_type = '' WHERE id = $1`
+	if _, err := tx.Exec(q, name); err == nil {
+		t.Fatal("expected the check constraint to reject an empty content type")
+	}
+}
+
+func TestNewRW(t *testing.T) {
+	primary, err := TestDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Rollback()
+	replica, err := TestDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replica.Rollback()
+
+	fsys := NewRW(primary, replica)
+
+	// Writes go to the primary, and can't be read through
+	// the replica until they're committed.
+	written := GenerateUUID()
+	info, err := fsys.WriteFile(written, BinaryType, nil, TestBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name() != written {
+		t.Fatal("Wanted:", written, "Got:", info.Name())
+	}
+	if _, err := New(primary).Stat(written); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Stat(written); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("Wanted:", fs.ErrNotExist, "Got:", err)
+	}
+	if err := fsys.Remove(written); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads go to the replica.
+	replicated := GenerateUUID()
+	createFile(t, New(replica), replicated, BinaryType, nil)
+	if _, err := fsys.Stat(replicated); err != nil {
+		t.Fatal(err)
+	}
+	b, err := fsys.ReadFile(replicated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, TestBytes) {
+		t.Fatal("bytes don't match")
+	}
+	entries, err := fsys.ReadDir("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, e := range entries {
+		found = found || e.Name() == replicated
+	}
+	if !found {
+		t.Fatal(replicated, "not listed")
+	}
+	if err := fsys.Remove(replicated); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("Wanted:", fs.ErrNotExist, "Got:", err)
+	}
+}
+
+func TestFileReadAt(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, nil)
+
+		f, err := fsys.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		ra := f.(io.ReaderAt)
+
+		head := make([]byte, 10)
+		if _, err := io.ReadFull(f, head); err != nil {
+			t.Fatal(err)
+		}
+
+		ranges := []struct{ off, n int64 }{
+			{0, 100},
+			{1000, 5000},
+			{20000, 1},
+			{int64(len(TestBytes)) - 500, 500},
+		}
+		var wg sync.WaitGroup
+		errs := make(chan error, len(ranges))
+		for _, r := range ranges {
+			wg.Add(1)
+			go func(off, n int64) {
+				defer wg.Done()
+				b, err := io.ReadAll(io.NewSectionReader(ra, off, n))
+				switch {
+				case err != nil:
+					errs <- err
+				case !bytes.Equal(b, TestBytes[off:off+n]):
+					errs <- fmt.Errorf("bytes don't match at offset %d", off)
+				default:
+					errs <- nil
+				}
+			}(r.off, r.n)
+		}
+		wg.Wait()
+		for range ranges {
+			if err := <-errs; err != nil {
+				t.Error(err)
+			}
+		}
+
+		n, err := ra.ReadAt(make([]byte, 100), int64(len(TestBytes))-10)
+		if n != 10 || err != io.EOF {
+			t.Error("Wanted: 10, EOF", "Got:", n, err)
+		}
+
+		// The sequential position was not disturbed.
+		next := make([]byte, 10)
+		if _, err := io.ReadFull(f, next); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(next, TestBytes[10:20]) {
+			t.Fatal("position was not restored")
+		}
+	})
+}
+
+func TestFileWriteAt(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, nil)
+
+		f, err := fsys.OpenFile(name, os.O_RDWR, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wa := f.(io.WriterAt)
+
+		patch := []byte("patched region")
+		if _, err := wa.WriteAt(patch, 1000); err != nil {
+			t.Fatal(err)
+		}
+		// Extend the file past its end.
+		tail := []byte("tail")
+		end := int64(len(TestBytes)) + 10
+		if _, err := wa.WriteAt(tail, end); err != nil {
+			t.Fatal(err)
+		}
+		// The position was not disturbed.
+		head := make([]byte, 10)
+		if _, err := io.ReadFull(f, head); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(head, TestBytes[:10]) {
+			t.Fatal("position was not restored")
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		wanted := make([]byte, end+int64(len(tail)))
+		copy(wanted, TestBytes)
+		copy(wanted[1000:], patch)
+		copy(wanted[end:], tail)
+
+		b, err := fsys.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, wanted) {
+			t.Fatal("bytes don't match")
+		}
+		info, err := fsys.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(len(wanted)) {
+			t.Fatal("Wanted:", len(wanted), "Got:", info.Size())
+		}
+		digest := sha256.Sum256(wanted)
+		if !bytes.Equal(info.(FileInfo).ContentSHA256(), digest[:]) {
+			t.Fatal("digests don't match")
+		}
+	})
+}
+
+func TestFSOpenReader(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, nil)
+
+		r, err := fsys.OpenReader(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		// Not a divisor of the size, so the last read is short.
+		buf := make([]byte, 7000)
+		h := sha256.New()
+		var total int
+		for {
+			n, err := r.Read(buf)
+			total += n
+			h.Write(buf[:n])
+			if err == io.EOF {
+				if n != 0 {
+					t.Fatal("EOF returned with data")
+				}
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(buf) && total != len(TestBytes) {
+				t.Fatal("short read before the end:", n)
+			}
+		}
+		if total != len(TestBytes) {
+			t.Fatal("Wanted:", len(TestBytes), "Got:", total)
+		}
+		if !bytes.Equal(h.Sum(nil), TestBytesSHA256) {
+			t.Fatal("digests don't match")
+		}
+	})
+}
+
+func TestFSPhysicalSize(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, nil)
+
+		n, err := fsys.PhysicalSize(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The test file is a PNG, which doesn't compress.
+		if n < int64(len(TestBytes)) {
+			t.Fatal("physical size", n, "is smaller than", len(TestBytes))
+		}
+
+		if _, err := fsys.PhysicalSize(GenerateUUID()); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal("Wanted:", fs.ErrNotExist, "Got:", err)
+		}
+	})
+}
+
+func TestFileServeHTTPContentDisposition(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		serve := func(fsys *FS, name string) string {
+			t.Helper()
+			f, err := fsys.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			w := httptest.NewRecorder()
+			ServeFile(w, httptest.NewRequest(http.MethodGet, "https://example.com", nil), f)
+			if w.Code != http.StatusOK {
+				t.Fatal("Wanted:", http.StatusOK, "Got:", w.Code)
+			}
+			return w.Header().Get("Content-Disposition")
+		}
+
+		plain := GenerateUUID()
+		createFile(t, fsys, plain, "image/png", nil)
+		if v := serve(fsys, plain); v != "" {
+			t.Error("unexpected Content-Disposition:", v)
+		}
+		if v := serve(fsys.Attachment(), plain); v != "attachment" {
+			t.Error("Wanted: attachment", "Got:", v)
+		}
+
+		named := GenerateUUID()
+		createFile(t, fsys, named, "image/png", Sys{FilenameKey: "gopher.png"})
+		if v := serve(fsys, named); v != "inline; filename=gopher.png" {
+			t.Error("unexpected Content-Disposition:", v)
+		}
+
+		utf8 := GenerateUUID()
+		createFile(t, fsys, utf8, "image/png", Sys{FilenameKey: "café gopher.png"})
+		v := serve(fsys.Attachment(), utf8)
+		if v != "attachment; filename*=utf-8''caf%C3%A9%20gopher.png" {
+			t.Error("unexpected Content-Disposition:", v)
+		}
+		_, params, err := mime.ParseMediaType(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if params["filename"] != "café gopher.png" {
+			t.Error("Wanted: café gopher.png", "Got:", params["filename"])
+		}
+	})
+}
+
+// nonSeekableFile hides everything but the fs.File methods of
+// the file it wraps.
+type nonSeekableFile struct {
+	fs.File
+}
+
+func TestServeFileFallback(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, "image/png", nil)
+		f, err := fsys.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		r := httptest.NewRequest(http.MethodGet, "https://example.com", nil)
+		r.Header.Set("If-None-Match", fmt.Sprintf(`"%x"`, TestBytesSHA256))
+		w := httptest.NewRecorder()
+		ServeFile(w, r, nonSeekableFile{f})
+		if w.Code != http.StatusNotModified {
+			t.Fatal("Wanted:", http.StatusNotModified, "Got:", w.Code)
+		}
+		if w.Body.Len() != 0 {
+			t.Fatal("body should be empty")
+		}
+
+		r = httptest.NewRequest(http.MethodGet, "https://example.com", nil)
+		w = httptest.NewRecorder()
+		ServeFile(w, r, nonSeekableFile{f})
+		if w.Code != http.StatusOK {
+			t.Fatal("Wanted:", http.StatusOK, "Got:", w.Code)
+		}
+		if got := w.Header().Get("Content-Length"); got != strconv.Itoa(len(TestBytes)) {
+			t.Fatal("Wanted:", len(TestBytes), "Got:", got)
+		}
+		if !bytes.Equal(w.Body.Bytes(), TestBytes) {
+			t.Fatal("bytes don't match")
+		}
+	})
+}
+
+func TestFSCreateSizeHint(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		// Looks like text in its first 512 bytes only.
+		data := append(bytes.Repeat([]byte("a"), 600), 0x00, 0x01, 0x02)
+
+		tests := []struct {
+			hint     int64
+			expected string
+		}{
+			{0, "text/plain; charset=utf-8"},
+			{int64(len(data)), BinaryType},
+		}
+		for _, test := range tests {
+			name := GenerateUUID()
+			w, err := fsys.CreateWithOptions(name, CreateOptions{SizeHint: test.hint})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := fsys.Stat(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info.(FileInfo).ContentType(); got != test.expected {
+				t.Error("Wanted:", test.expected, "Got:", got)
+			}
+		}
+	})
+}
+
+func TestFSOpenPipeline(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		if _, err := fsys.WriteFile(name, "text/plain", nil, []byte("hello, gopher")); err != nil {
+			t.Fatal(err)
+		}
+
+		upper := func(r io.Reader) io.Reader {
+			b, err := io.ReadAll(r)
+			if err != nil {
+				return iotest.ErrReader(err)
+			}
+			return bytes.NewReader(bytes.ToUpper(b))
+		}
+		limit := func(r io.Reader) io.Reader {
+			return io.LimitReader(r, 5)
+		}
+
+		rc, err := fsys.OpenPipeline(name, upper, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "HELLO" {
+			t.Fatal("Wanted:", "HELLO", "Got:", string(got))
+		}
+		if err := rc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := rc.Close(); !errors.Is(err, fs.ErrClosed) {
+			t.Fatal("Wanted:", fs.ErrClosed, "Got:", err)
+		}
+
+		if _, err := fsys.OpenPipeline(GenerateUUID()); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal("Wanted:", fs.ErrNotExist, "Got:", err)
+		}
+	})
+}
+
+func TestServeHead(t *testing.T) {
+	sqlTx, err := TestDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlTx.Rollback()
+
+	tx := &countingTx{Tx: sqlTx}
+	fsys := New(tx)
+
+	name := GenerateUUID()
+	createFile(t, fsys, name, "image/png", nil)
+
+	check := func(t *testing.T, w *httptest.ResponseRecorder) {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatal("Wanted:", http.StatusOK, "Got:", w.Code)
+		}
+		if w.Body.Len() != 0 {
+			t.Fatal("body should be empty")
+		}
+		for key, value := range map[string]string{
+			"Accept-Ranges":  "bytes",
+			"Content-Length": strconv.Itoa(len(TestBytes)),
+			"Content-Type":   "image/png",
+			"ETag":           `"` + hex.EncodeToString(TestBytesSHA256) + `"`,
+		} {
+			if got := w.Header().Get(key); got != value {
+				t.Error(key, "Wanted:", value, "Got:", got)
+			}
+		}
+	}
+
+	t.Run("file", func(t *testing.T) {
+		f, err := fsys.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		tx.match, tx.n = "loread(", 0
+		r := httptest.NewRequest(http.MethodHead, "https://example.com", nil)
+		w := httptest.NewRecorder()
+		f.(http.Handler).ServeHTTP(w, r)
+		check(t, w)
+		if tx.n != 0 {
+			t.Fatal("content was read")
+		}
+	})
+
+	t.Run("name", func(t *testing.T) {
+		tx.match, tx.n = "lo_open(", 0
+		r := httptest.NewRequest(http.MethodHead, "https://example.com", nil)
+		w := httptest.NewRecorder()
+		fsys.ServeName(w, r, name)
+		check(t, w)
+		if tx.n != 0 {
+			t.Fatal("a descriptor was opened")
+		}
+	})
+
+	t.Run("get", func(t *testing.T) {
+		f, err := fsys.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		r := httptest.NewRequest(http.MethodGet, "https://example.com", nil)
+		w := httptest.NewRecorder()
+		f.(http.Handler).ServeHTTP(w, r)
+		if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+			t.Fatal("Wanted:", "bytes", "Got:", got)
+		}
+		if !bytes.Equal(w.Body.Bytes(), TestBytes) {
+			t.Fatal("bytes don't match")
+		}
+	})
+}
+
+func TestFSExists(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, nil)
+
+		tests := []struct {
+			name     string
+			expected bool
+		}{
+			{name, true},
+			{GenerateUUID(), false},
+			{"not-a-uuid", false},
+		}
+		for _, test := range tests {
+			ok, err := fsys.Exists(test.name)
+			if err != nil {
+				t.Fatal(test.name, err)
+			}
+			if ok != test.expected {
+				t.Error(test.name, "Wanted:", test.expected, "Got:", ok)
+			}
+		}
+	})
+}
+
+// testFileSystem checks the behavior shared by all
+// implementations of [FileSystem].
+func testFileSystem(t *testing.T, fsys FileSystem) {
+	t.Helper()
+
+	name := GenerateUUID()
+	sys := Sys{"key": "value"}
+	w, err := fsys.Create(name, "image/png", sys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(TestBytes); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sys["key"] = "changed"
+
+	if _, err := fsys.Create(name, "image/png", nil); !errors.Is(err, fs.ErrExist) {
+		t.Fatal("Wanted:", fs.ErrExist, "Got:", err)
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.(io.Seeker).Seek(0, io.SeekStart); !errors.Is(err, fs.ErrClosed) {
+		t.Fatal("Wanted:", fs.ErrClosed, "Got:", err)
+	}
+	if _, err := f.(io.ReaderAt).ReadAt(make([]byte, 1), 0); !errors.Is(err, fs.ErrClosed) {
+		t.Fatal("Wanted:", fs.ErrClosed, "Got:", err)
+	}
+
+	b, err := fsys.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, TestBytes) {
+		t.Fatal("bytes don't match")
+	}
+
+	info, err := fsys.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name() != name || info.Size() != int64(len(TestBytes)) {
+		t.Fatal("info doesn't match")
+	}
+	if got := info.(FileInfo).ContentType(); got != "image/png" {
+		t.Fatal("Wanted:", "image/png", "Got:", got)
+	}
+	if !bytes.Equal(info.(FileInfo).ContentSHA256(), TestBytesSHA256) {
+		t.Fatal("digests don't match")
+	}
+	if Meta(info)["key"] != "value" {
+		t.Fatal("sys was not stored")
+	}
+	info.Sys().(Sys)["key"] = "changed"
+	if info, _ := fsys.Stat(name); Meta(info)["key"] != "value" {
+		t.Fatal("stored sys was modified")
+	}
+
+	entries, err := fsys.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(entries, func(e fs.DirEntry) bool { return e.Name() == name }) {
+		t.Fatal("file not listed")
+	}
+
+	// Only the first 512 bytes are used to detect content types.
+	detected := GenerateUUID()
+	w, err = fsys.Create(detected, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := append(bytes.Repeat([]byte("a"), 600), 0x00)
+	if _, err := w.Write(text); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := fsys.Stat(detected); err != nil {
+		t.Fatal(err)
+	} else if got, wanted := info.(FileInfo).ContentType(), http.DetectContentType(text); got != wanted {
+		t.Fatal("Wanted:", wanted, "Got:", got)
+	}
+
+	if ok, err := fsys.Exists(name); err != nil || !ok {
+		t.Fatal("Wanted:", true, "Got:", ok, err)
+	}
+	if err := fsys.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fsys.Exists(name); err != nil || ok {
+		t.Fatal("Wanted:", false, "Got:", ok, err)
+	}
+	if _, err := fsys.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("Wanted:", fs.ErrNotExist, "Got:", err)
+	}
+	if _, err := fsys.Open(name); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("Wanted:", fs.ErrNotExist, "Got:", err)
+	}
+	if err := fsys.Remove(name); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("Wanted:", fs.ErrNotExist, "Got:", err)
+	}
+}
+
+func TestFileSystem(t *testing.T) {
+	t.Run("pgfs", func(t *testing.T) {
+		withFS(t, func(fsys *FS) {
+			testFileSystem(t, fsys)
+		})
+	})
+	t.Run("mem", func(t *testing.T) {
+		testFileSystem(t, NewMemFS())
+	})
+}
+
+func TestFSCount(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		before, err := fsys.Count()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		batch := GenerateUUID()
+		const n = 3
+		for i := 0; i < n; i++ {
+			createFile(t, fsys, GenerateUUID(), BinaryType, Sys{"batch": batch, "i": strconv.Itoa(i)})
+		}
+
+		count, err := fsys.Count()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != before+n {
+			t.Fatal("Wanted:", before+n, "Got:", count)
+		}
+
+		tests := []struct {
+			filter   Sys
+			expected int64
+		}{
+			{Sys{"batch": batch}, n},
+			{Sys{"batch": batch, "i": "1"}, 1},
+			{Sys{"batch": GenerateUUID()}, 0},
+			{nil, before + n},
+		}
+		for _, test := range tests {
+			count, err := fsys.CountWhere(test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != test.expected {
+				t.Error(test.filter, "Wanted:", test.expected, "Got:", count)
+			}
+		}
+	})
+}
+
+func TestFSExportImportFile(t *testing.T) {
+	name := GenerateUUID()
+	sys := Sys{"key": "value"}
+	var blob bytes.Buffer
+	var exported FileInfo
+
+	// The source transaction is rolled back, so the
+	// file only exists in the destination.
+	src, err := TestDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	func() {
+		defer src.Rollback()
+
+		fsys := New(src)
+		w, err := fsys.CreateWithOptions(name, CreateOptions{
+			ContentType: "image/png",
+			Sys:         sys,
+			RawMeta:     []byte{0x01, 0x02},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(TestBytes); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		info, err := fsys.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exported = info.(FileInfo)
+
+		if err := fsys.ExportFile(name, &blob); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	withFS(t, func(fsys *FS) {
+		corrupted := bytes.Clone(blob.Bytes())
+		corrupted[len(corrupted)-1] ^= 0xFF
+		if _, err := fsys.ImportFile(bytes.NewReader(corrupted)); !errors.Is(err, ErrDigestMismatch) {
+			t.Fatal("Wanted:", ErrDigestMismatch, "Got:", err)
+		}
+		if ok, _ := fsys.Exists(name); ok {
+			t.Fatal("corrupted file was imported")
+		}
+
+		info, err := fsys.ImportFile(&blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Name() != name {
+			t.Fatal("Wanted:", name, "Got:", info.Name())
+		}
+		if info.ContentType() != "image/png" {
+			t.Fatal("Wanted:", "image/png", "Got:", info.ContentType())
+		}
+		if !bytes.Equal(info.ContentSHA256(), TestBytesSHA256) {
+			t.Fatal("digests don't match")
+		}
+		if !bytes.Equal(info.RawMeta(), []byte{0x01, 0x02}) {
+			t.Fatal("raw metadata doesn't match")
+		}
+		if Meta(info)["key"] != "value" {
+			t.Fatal("sys doesn't match")
+		}
+		if !info.ModTime().Equal(exported.ModTime()) {
+			t.Fatal("Wanted:", exported.ModTime(), "Got:", info.ModTime())
+		}
+
+		b, err := fsys.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, TestBytes) {
+			t.Fatal("bytes don't match")
+		}
+
+		// Bytes following the JSON value in the header,
+		// like a newline, aren't part of the content.
+		padded := GenerateUUID()
+		content := TestBytes[:100]
+		sum := sha256.Sum256(content)
+		header, err := json.Marshal(exportHeader{
+			Name:          padded,
+			ContentType:   BinaryType,
+			ContentSize:   int64(len(content)),
+			ContentSHA256: sum[:],
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		header = append(header, '\n')
+		var r bytes.Buffer
+		r.WriteString(exportMagic)
+		binary.Write(&r, binary.BigEndian, uint32(len(header)))
+		r.Write(header)
+		r.Write(content)
+		if _, err := fsys.ImportFile(&r); err != nil {
+			t.Fatal(err)
+		}
+		if b, err := fsys.ReadFile(padded); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(b, content) {
+			t.Fatal("bytes don't match")
+		}
+	})
+}
+
+func TestFSTotalSize(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		before, err := fsys.TotalSize()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var sum int64
+		for _, size := range []int{0, 1, 1024, len(TestBytes)} {
+			createFileBytes(t, fsys, GenerateUUID(), BinaryType, nil, TestBytes[:size])
+			sum += int64(size)
+		}
+
+		total, err := fsys.TotalSize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != before+sum {
+			t.Fatal("Wanted:", before+sum, "Got:", total)
+		}
+
+		root, err := fsys.Stat("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if root.Size() != total {
+			t.Fatal("Wanted:", total, "Got:", root.Size())
+		}
+	})
+}
+
+func TestFSRotate(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		oldName := GenerateUUID()
+		createFileBytes(t, fsys, oldName, "text/plain", nil, []byte("old"))
+
+		// An aborted write leaves the old file intact.
+		newName := GenerateUUID()
+		wc, err := fsys.Rotate(oldName, newName, "text/plain", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wc.Write([]byte("new")); err != nil {
+			t.Fatal(err)
+		}
+		if err := wc.(*writer).abort(); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := fsys.Exists(oldName); err != nil || !ok {
+			t.Fatal("old file should exist", err)
+		}
+		if ok, err := fsys.Exists(newName); err != nil || ok {
+			t.Fatal("new file should not exist", err)
+		}
+
+		wc, err = fsys.Rotate(oldName, newName, "text/plain", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wc.Write([]byte("new")); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := fsys.Exists(oldName); err != nil || !ok {
+			t.Fatal("old file should exist until closed", err)
+		}
+		if err := wc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := fsys.Exists(oldName); err != nil || ok {
+			t.Fatal("old file should be removed", err)
+		}
+		b, err := fsys.ReadFile(newName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "new" {
+			t.Fatal("Wanted:", "new", "Got:", string(b))
+		}
+
+		if _, err := fsys.Rotate(oldName, GenerateUUID(), "text/plain", nil); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal("Wanted:", fs.ErrNotExist, "Got:", err)
+		}
+	})
+}
+
+func TestFSCompressionStats(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		var ok bool
+		const q = `SELECT has_table_privilege('pg_largeobject', 'SELECT')`
+		if err := fsys.conn.QueryRow(q).Scan(&ok); err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatal("the test role can't read pg_largeobject, which compressed sizes require")
+		}
+
+		before, err := fsys.CompressionStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		const size = 1 << 20
+		name := GenerateUUID()
+		createFileBytes(t, fsys, name, BinaryType, nil, make([]byte, size))
+
+		info, err := fsys.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.(FileInfo).CompressedSize(); got != -1 {
+			t.Fatal("Wanted:", -1, "Got:", got)
+		}
+		info, err = fsys.ReportCompressedSize().Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.(FileInfo).CompressedSize(); got <= 0 || got >= info.Size() {
+			t.Fatal("compressed size", got, "is not smaller than", info.Size())
+		}
+
+		after, err := fsys.CompressionStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := after.Size - before.Size; got != size {
+			t.Fatal("Wanted:", size, "Got:", got)
+		}
+		// Zeros compress well.
+		if got := after.PhysicalSize - before.PhysicalSize; got >= size {
+			t.Fatal("physical size", got, "is not smaller than", size)
+		}
+		if after.Saved() <= before.Saved() {
+			t.Fatal("no space saved")
+		}
+	})
+}
+
+func TestFSReadDirAfter(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		const n = 1000
+		batch := GenerateUUID()
+		for i := 0; i < n; i++ {
+			createFileBytes(t, fsys, GenerateUUID(), BinaryType, Sys{"batch": batch}, []byte{byte(i)})
+		}
+
+		seen := make(map[string]int)
+		var cursor, last string
+		for {
+			entries, next, err := fsys.ReadDirAfter(cursor, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if e.Name() <= last {
+					t.Fatal("entries are not ordered")
+				}
+				last = e.Name()
+				info, _ := e.Info()
+				if Meta(info)["batch"] == batch {
+					seen[e.Name()]++
+				}
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+
+		if len(seen) != n {
+			t.Fatal("Wanted:", n, "Got:", len(seen))
+		}
+		for name, count := range seen {
+			if count != 1 {
+				t.Fatal(name, "seen", count, "times")
+			}
+		}
+
+		if _, _, err := fsys.ReadDirAfter("not-a-uuid", 10); !errors.Is(err, fs.ErrInvalid) {
+			t.Fatal("Wanted:", fs.ErrInvalid, "Got:", err)
+		}
+	})
+}
+
+// readSizeTx is a [Tx] that records the number of
+// bytes requested by each call to loread.
+type readSizeTx struct {
+	*sql.Tx
+	sizes []int
+}
+
+func (tx *readSizeTx) record(query string, args []any) {
+	if strings.Contains(query, "loread(") {
+		tx.sizes = append(tx.sizes, args[1].(int))
+	}
+}
+
+func (tx *readSizeTx) QueryRow(query string, args ...any) *sql.Row {
+	tx.record(query, args)
+	return tx.Tx.QueryRow(query, args...)
+}
+
+func (tx *readSizeTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	tx.record(query, args)
+	return tx.Tx.QueryRowContext(ctx, query, args...)
+}
+
+func TestFSMaxReadSize(t *testing.T) {
+	sqlTx, err := TestDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlTx.Rollback()
+
+	tx := &readSizeTx{Tx: sqlTx}
+	fsys := New(tx).MaxReadSize(1 << 20)
+
+	const size = 10 << 20
+	data, err := io.ReadAll(io.LimitReader(&loopingReader{src: TestBytes}, size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := GenerateUUID()
+	createFileBytes(t, fsys, name, BinaryType, nil, data)
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tx.sizes = nil
+	buf := make([]byte, size)
+	n, err := f.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != size {
+		t.Fatal("Wanted:", size, "Got:", n)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Fatal("bytes don't match")
+	}
+	if len(tx.sizes) < 10 {
+		t.Fatal("Wanted at least 10 calls, Got:", len(tx.sizes))
+	}
+	for _, s := range tx.sizes {
+		if s > 1<<20 {
+			t.Fatal("requested", s, "bytes")
+		}
+	}
+
+	tx.sizes = nil
+	const off = 123
+	n, err = f.(io.ReaderAt).ReadAt(buf[:size-off], off)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != size-off {
+		t.Fatal("Wanted:", size-off, "Got:", n)
+	}
+	if !bytes.Equal(buf[:size-off], data[off:]) {
+		t.Fatal("bytes don't match")
+	}
+	if len(tx.sizes) < 10 {
+		t.Fatal("Wanted at least 10 calls, Got:", len(tx.sizes))
+	}
+	for _, s := range tx.sizes {
+		if s > 1<<20 {
+			t.Fatal("requested", s, "bytes")
+		}
+	}
+}
+
+func TestFSReadDirSorted(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		a, b, c := GenerateUUID(), GenerateUUID(), GenerateUUID()
+		createFileBytes(t, fsys, a, "text/plain", nil, []byte("abc"))
+		createFileBytes(t, fsys, b, "application/pdf", nil, []byte("abcdef"))
+		createFileBytes(t, fsys, c, "image/png", nil, []byte("a"))
+
+		byName := []string{a, b, c}
+		slices.Sort(byName)
+		reversed := func(names []string) []string {
+			r := slices.Clone(names)
+			slices.Reverse(r)
+			return r
+		}
+
+		// Files created in the same transaction share
+		// their creation time, so ties are broken by name.
+		tests := []struct {
+			order    DirOrder
+			expected []string
+		}{
+			{ByName, byName},
+			{ByNameDesc, reversed(byName)},
+			{ByCreatedAt, byName},
+			{ByCreatedAtDesc, byName},
+			{BySize, []string{c, a, b}},
+			{BySizeDesc, []string{b, a, c}},
+			{ByContentType, []string{b, c, a}},
+			{ByContentTypeDesc, []string{a, c, b}},
+		}
+		for _, test := range tests {
+			entries, err := fsys.ReadDirSorted(test.order, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries {
+				if e.Name() == a || e.Name() == b || e.Name() == c {
+					got = append(got, e.Name())
+				}
+			}
+			if !slices.Equal(got, test.expected) {
+				t.Error("order", test.order, "Wanted:", test.expected, "Got:", got)
+			}
+
+			page, err := fsys.ReadDirSorted(test.order, 2, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page) != 2 || page[0].Name() != entries[1].Name() || page[1].Name() != entries[2].Name() {
+				t.Error("page doesn't match order", test.order)
+			}
+		}
+
+		if _, err := fsys.ReadDirSorted(DirOrder(-1), 0, 0); err == nil {
+			t.Fatal("invalid order was accepted")
+		}
+	})
+}
+
+func TestUUIDFromContent(t *testing.T) {
+	a := UUIDFromContent(TestBytes)
+	if !ValidPath(a) {
+		t.Fatal("invalid UUID:", a)
+	}
+	if b := UUIDFromContent(bytes.Clone(TestBytes)); a != b {
+		t.Fatal("Wanted:", a, "Got:", b)
+	}
+	if b := UUIDFromContent(TestBytes[1:]); a == b {
+		t.Fatal("different content has the same UUID")
+	}
+	if v := uuid.MustParse(a).Version(); v != 5 {
+		t.Fatal("Wanted:", 5, "Got:", v)
+	}
+}
+
+func TestFSReadDirFilter(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		batch := GenerateUUID()
+		invoices := []string{GenerateUUID(), GenerateUUID()}
+		slices.Sort(invoices)
+		for _, name := range invoices {
+			createFile(t, fsys, name, BinaryType, Sys{"batch": batch, "category": "invoice"})
+		}
+		receipt := GenerateUUID()
+		createFile(t, fsys, receipt, BinaryType, Sys{"batch": batch, "category": "receipt"})
+		createFile(t, fsys, GenerateUUID(), BinaryType, nil)
+
+		names := func(entries []fs.DirEntry) []string {
+			r := make([]string, len(entries))
+			for i, e := range entries {
+				r[i] = e.Name()
+			}
+			return r
+		}
+
+		entries, err := fsys.ReadDirFilter(Sys{"batch": batch, "category": "invoice"}, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := names(entries); !slices.Equal(got, invoices) {
+			t.Fatal("Wanted:", invoices, "Got:", got)
+		}
+
+		entries, err = fsys.ReadDirFilter(Sys{"batch": batch, "category": "invoice"}, 1, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := names(entries); !slices.Equal(got, invoices[1:]) {
+			t.Fatal("Wanted:", invoices[1:], "Got:", got)
+		}
+
+		entries, err = fsys.ReadDirFilter(Sys{"batch": batch}, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 3 {
+			t.Fatal("Wanted:", 3, "Got:", len(entries))
+		}
+
+		all, err := fsys.ReadDir(".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, match := range []Sys{nil, {}} {
+			entries, err := fsys.ReadDirFilter(match, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(all) {
+				t.Fatal("Wanted:", len(all), "Got:", len(entries))
+			}
+		}
+	})
+}
+
+func TestFSExportToBytea(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, "image/png", nil)
+
+		b, err := fsys.ExportToBytea(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, TestBytes) {
+			t.Fatal("bytes don't match")
+		}
+
+		if _, err := fsys.ExportToBytea(GenerateUUID()); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal("Wanted:", fs.ErrNotExist, "Got:", err)
+		}
+	})
+}
+
+func TestFSReadDirByContentType(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		png, pdf, txt := GenerateUUID(), GenerateUUID(), GenerateUUID()
+		createFile(t, fsys, png, "image/png", nil)
+		createFile(t, fsys, pdf, "application/pdf", nil)
+		createFile(t, fsys, txt, "text/plain", nil)
+
+		tests := []struct {
+			prefix   string
+			expected []string
+		}{
+			{"image/", []string{png}},
+			{"image/png", []string{png}},
+			{"application/pdf", []string{pdf}},
+			{"text/", []string{txt}},
+			{"", []string{png, pdf, txt}},
+			{"_mage/", nil},
+			{"%", nil},
+		}
+		for _, test := range tests {
+			entries, err := fsys.ReadDirByContentType(test.prefix, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries {
+				if slices.Contains([]string{png, pdf, txt}, e.Name()) {
+					got = append(got, e.Name())
+				}
+				if ct := e.(FileInfo).ContentType(); !strings.HasPrefix(ct, test.prefix) {
+					t.Error(test.prefix, "unexpected content type:", ct)
+				}
+			}
+			slices.Sort(got)
+			slices.Sort(test.expected)
+			if !slices.Equal(got, test.expected) {
+				t.Error(test.prefix, "Wanted:", test.expected, "Got:", got)
+			}
+		}
+	})
+}
+
+func TestFSCreateFromBytea(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		sys := Sys{"key": "value"}
+		streamed := GenerateUUID()
+		createFile(t, fsys, streamed, "", sys)
+		want, err := fsys.Stat(streamed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		name := GenerateUUID()
+		info, err := fsys.CreateFromBytea(name, "", sys, TestBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != want.Size() {
+			t.Fatal("Wanted:", want.Size(), "Got:", info.Size())
+		}
+		if info.ContentType() != want.(FileInfo).ContentType() {
+			t.Fatal("Wanted:", want.(FileInfo).ContentType(), "Got:", info.ContentType())
+		}
+		if !bytes.Equal(info.ContentSHA256(), want.(FileInfo).ContentSHA256()) {
+			t.Fatal("digests don't match")
+		}
+		if !maps.Equal(Meta(info), Meta(want)) {
+			t.Fatal("Wanted:", Meta(want), "Got:", Meta(info))
+		}
+
+		b, err := fsys.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, TestBytes) {
+			t.Fatal("bytes don't match")
+		}
+
+		if _, err := fsys.CreateFromBytea(name, "", nil, TestBytes); !errors.Is(err, fs.ErrExist) {
+			t.Fatal("Wanted:", fs.ErrExist, "Got:", err)
+		}
+
+		for _, data := range [][]byte{nil, {}} {
+			name := GenerateUUID()
+			info, err := fsys.CreateFromBytea(name, BinaryType, nil, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != 0 {
+				t.Fatal("Wanted:", 0, "Got:", info.Size())
+			}
+			b, err := fsys.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(b) != 0 {
+				t.Fatal("file should be empty")
+			}
+		}
+	})
+}
+
+func TestFSGlob(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, BinaryType, nil)
+
+		tests := []struct {
+			pattern string
+			matches bool
+		}{
+			{name[:8] + "*", true},
+			{name, true},
+			{name[:7] + "?" + name[8:], true},
+			{"*", true},
+			{"zzzz*", false},
+			{strings.Repeat("0", 35) + "*", false},
+		}
+		for _, test := range tests {
+			names, err := fs.Glob(fsys, test.pattern)
+			if err != nil {
+				t.Fatal(test.pattern, err)
+			}
+			if got := slices.Contains(names, name); got != test.matches {
+				t.Error(test.pattern, "Wanted:", test.matches, "Got:", got)
+			}
+			for _, n := range names {
+				if ok, _ := path.Match(test.pattern, n); !ok {
+					t.Error(test.pattern, "unexpected match:", n)
+				}
+			}
+		}
+
+		if _, err := fsys.Glob("["); !errors.Is(err, path.ErrBadPattern) {
+			t.Fatal("Wanted:", path.ErrBadPattern, "Got:", err)
+		}
+	})
+}
+
+func TestFSListNonEmpty(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		empty, full := GenerateUUID(), GenerateUUID()
+		createFileBytes(t, fsys, empty, BinaryType, nil, nil)
+		createFile(t, fsys, full, BinaryType, nil)
+
+		entries, err := fsys.ListNonEmpty()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() == 0 {
+				t.Fatal("empty file listed:", e.Name())
+			}
+			names = append(names, e.Name())
+		}
+		if !slices.Contains(names, full) {
+			t.Fatal("non-empty file not listed")
+		}
+		if slices.Contains(names, empty) {
+			t.Fatal("empty file listed")
+		}
+	})
+}
+
+func TestFSReadFileFS(t *testing.T) {
+	sqlTx, err := TestDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlTx.Rollback()
+
+	tx := &countingTx{Tx: sqlTx, match: "pgfs_metadata"}
+	fsys := New(tx)
+
+	name := GenerateUUID()
+	createFile(t, fsys, name, BinaryType, nil)
+
+	// Without the method, fs.ReadFile would
+	// also stat the file to size its buffer.
+	tx.n = 0
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, TestBytes) {
+		t.Fatal("bytes don't match")
+	}
+	if tx.n != 1 {
+		t.Fatal("Wanted:", 1, "Got:", tx.n)
+	}
+
+	if _, err := fs.ReadFile(fsys, GenerateUUID()); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("Wanted:", fs.ErrNotExist, "Got:", err)
+	}
+}
+
+func TestHTTPFileSystem(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		name := GenerateUUID()
+		createFile(t, fsys, name, "image/png", nil)
+
+		server := httptest.NewServer(http.FileServer(HTTPFileSystem(fsys)))
+		defer server.Close()
+
+		get := func(path string) (int, []byte) {
+			t.Helper()
+			resp, err := server.Client().Get(server.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return resp.StatusCode, b
+		}
+
+		code, b := get("/" + name)
+		if code != http.StatusOK {
+			t.Fatal("Wanted:", http.StatusOK, "Got:", code)
+		}
+		if !bytes.Equal(b, TestBytes) {
+			t.Fatal("bytes don't match")
+		}
+
+		code, b = get("/")
+		if code != http.StatusOK {
+			t.Fatal("Wanted:", http.StatusOK, "Got:", code)
+		}
+		if !bytes.Contains(b, []byte(name)) {
+			t.Fatal("file not listed")
+		}
+
+		for _, path := range []string{"/" + GenerateUUID(), "/not-a-uuid"} {
+			if code, _ := get(path); code != http.StatusNotFound {
+				t.Error(path, "Wanted:", http.StatusNotFound, "Got:", code)
+			}
+		}
+	})
+}
+
+// tagSink makes the unpooled buffers of TestTagPoolAllocs
+// escape to the heap, like the ones of a writer.
+var tagSink []byte
+
+func TestTagPoolAllocs(t *testing.T) {
+	putTag(getTag())
+
+	pooled := testing.AllocsPerRun(100, func() {
+		b := getTag()
+		*b = append(*b, TestBytes[:512]...)
+		putTag(b)
+	})
+	// The race detector drops some of the buffers put in a
+	// sync.Pool, so the bound is less than one per run.
+	if pooled >= 1 {
+		t.Fatalf("pooled tags: %v allocs per run, want less than 1", pooled)
+	}
+
+	unpooled := testing.AllocsPerRun(100, func() {
+		tagSink = make([]byte, 0, 512)
+		tagSink = append(tagSink, TestBytes[:512]...)
+	})
+	tagSink = nil
+	if pooled >= unpooled {
+		t.Fatalf("pooled tags: %v allocs per run, unpooled: %v", pooled, unpooled)
+	}
+}
+
+func TestFSCreateTagPool(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		w, err := fsys.Create(GenerateUUID(), "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tag := w.(*writer).pooledTag; tag == nil || cap(*tag) != 512 {
+			t.Fatal("tag was not taken from the pool")
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if w.(*writer).pooledTag != nil {
+			t.Fatal("tag was not returned to the pool")
+		}
+
+		// A SizeHint above 512 bytes takes the same path,
+		// except for the tag, which is allocated instead.
+		create := func(opts CreateOptions) func() {
+			return func() {
+				w, err := fsys.CreateWithOptions(GenerateUUID(), opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := w.Write(TestBytes[:1024]); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		pooled := testing.AllocsPerRun(50, create(CreateOptions{}))
+		unpooled := testing.AllocsPerRun(50, create(CreateOptions{SizeHint: 513}))
+		if pooled >= unpooled {
+			t.Fatalf("pooled tags: %v allocs per file, unpooled: %v", pooled, unpooled)
+		}
+	})
+}
+
+func TestFSCreateCloseCanceled(t *testing.T) {
+	withFS(t, func(fsys *FS) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		w, err := fsys.CreateContext(ctx, GenerateUUID(), BinaryType, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(TestBytes); err != nil {
+			t.Fatal(err)
+		}
+
+		cancel()
+		if err := w.Close(); !errors.Is(err, context.Canceled) {
+			t.Fatal("Wanted:", context.Canceled, "Got:", err)
+		}
+
+		var exists bool
+		const q = `SELECT EXISTS(SELECT 1 FROM pg_largeobject_metadata WHERE oid = $1)`
+		if err := fsys.conn.QueryRow(q, w.(*writer).oid).Scan(&exists); err != nil {
+			t.Fatal(err)
+		}
+		if exists {
+			t.Fatal("large object was not deleted")
+		}
+
+		// Closing the descriptor again fails if it was released.
+		if _, err := fsys.conn.Exec(`SAVEPOINT descriptor`); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fsys.conn.Exec(`SELECT lo_close($1)`, w.(*writer).fd); err == nil {
+			t.Fatal("descriptor was not released")
+		}
+		if _, err := fsys.conn.Exec(`ROLLBACK TO SAVEPOINT descriptor`); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestNewDBCreateCloseCanceled(t *testing.T) {
+	fsys := NewDB(TestDB)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := fsys.CreateContext(ctx, GenerateUUID(), BinaryType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(TestBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	if err := w.Close(); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// The large object is rolled back with the transaction.
+	var exists bool
+	const q = `SELECT EXISTS(SELECT 1 FROM pg_largeobject_metadata WHERE oid = $1)`
+	if err := TestDB.QueryRow(q, w.(*writer).oid).Scan(&exists); err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("large object was not deleted")
+	}
+}
+
+// failingInsertTx is a [Tx] that fails to insert metadata
+// rows without sending the query, so the transaction can
+// still be used.
+type failingInsertTx struct {
+	*sql.Tx
+}
+
+func (tx *failingInsertTx) Exec(query string, args ...any) (sql.Result, error) {
+	if strings.Contains(query, "INSERT INTO pgfs_metadata") {
+		return nil, errors.New("insert failed")
+	}
+	return tx.Tx.Exec(query, args...)
+}
+
+func TestFSCopyError(t *testing.T) {
+	sqlTx, err := TestDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlTx.Rollback()
+
+	src := GenerateUUID()
+	createFile(t, New(sqlTx), src, BinaryType, nil)
+
+	fsys := New(&failingInsertTx{Tx: sqlTx})
+	before, err := fsys.LargeObjectCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := GenerateUUID()
+	if _, err := fsys.Copy(src, dst, nil); err == nil {
+		t.Fatal("expected an error")
+	}
+	if ok, _ := fsys.Exists(dst); ok {
+		t.Fatal("copy was created")
+	}
+
+	after, err := fsys.LargeObjectCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after != before {
+		t.Fatal("large object was leaked. Wanted:", before, "Got:", after)
+	}
+}
+
+func TestNewDBCopy(t *testing.T) {
+	fsys := NewDB(TestDB)
+
+	src := GenerateUUID()
+	createFile(t, fsys, src, BinaryType, nil)
+	defer fsys.Remove(src)
+
+	dst := GenerateUUID()
+	if _, err := fsys.Copy(src, dst, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Remove(dst)
+
+	b, err := fsys.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, TestBytes) {
+		t.Fatal("bytes don't match")
+	}
+}
+
 func TestMain(m *testing.M) {
 	connURL := os.Getenv("POSTGRES_URL")
 	if connURL == "" {
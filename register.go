@@ -0,0 +1,234 @@
+package pgfs
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// RegisterItem describes a large object that already exists in
+// the database, and that should be tracked by [FS] under Name.
+type RegisterItem struct {
+	Name        string
+	OID         OID
+	ContentType string
+	Sys         Sys
+}
+
+// Register inserts a metadata row for an existing large object
+// that was written outside of this package, such as with lo_import.
+//
+// See [FS.RegisterBatch] for more details.
+func (fsys *FS) Register(name string, oid OID, contentType string, sys Sys) error {
+	return fsys.RegisterBatch([]RegisterItem{{
+		Name:        name,
+		OID:         oid,
+		ContentType: contentType,
+		Sys:         sys,
+	}})
+}
+
+// RegisterBatch inserts the metadata rows of several existing large
+// objects in a single query.
+//
+// The size and the SHA-256 digest of each object are computed by the
+// server, which has to load each object in memory to do so. Objects
+// must therefore be smaller than 1GB.
+//
+// If a content type is empty, [BinaryType] is used.
+func (fsys *FS) RegisterBatch(items []RegisterItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	if err := fsys.checkWrite("register", items[0].Name); err != nil {
+		return err
+	}
+
+	values := make([]string, 0, len(items))
+	args := make([]any, 0, 4*len(items))
+	for _, item := range items {
+		id, err := uuid.Parse(item.Name)
+		if err != nil {
+			return &fs.PathError{Op: "register", Path: item.Name, Err: err}
+		}
+
+		contentType := item.ContentType
+		if contentType == "" {
+			contentType = BinaryType
+		}
+
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d::uuid, $%d::oid, $%d::jsonb, $%d::text)", n+1, n+2, n+3, n+4))
+		args = append(args, id, item.OID, item.Sys, contentType)
+	}
+
+	q := `
+		INSERT INTO pgfs_metadata (
+			id, oid, sys,
+			content_size, content_type, content_sha256
+		)
+		SELECT
+			v.id, v.oid, v.sys,
+			length(lo_get(v.oid)), v.content_type, sha256(lo_get(v.oid))
+		FROM (VALUES ` + strings.Join(values, ", ") + `) AS v (id, oid, sys, content_type)
+	`
+	_, err := fsys.conn.Exec(q, args...)
+	return err
+}
+
+// ExportLO writes the content of the file with the given name to
+// serverPath on the file system of the database server, using
+// lo_export.
+//
+// The operation requires the role to be a superuser or a member
+// of pg_write_server_files.
+func (fsys *FS) ExportLO(name, serverPath string) error {
+	id, err := uuid.Parse(name)
+	if err != nil {
+		return fs.ErrNotExist
+	}
+
+	const q = `
+		SELECT lo_export(oid, $2)
+		FROM pgfs_metadata
+		WHERE id = $1
+	`
+	var result int
+	err = fsys.conn.QueryRow(q, id, serverPath).Scan(&result)
+	switch {
+	case err == sql.ErrNoRows:
+		err = fs.ErrNotExist
+	case err != nil:
+		break
+	case result != 1:
+		err = errors.New("error exporting large object")
+	}
+	return err
+}
+
+// ExportToBytea returns the content of the file with the given
+// name in a single query using lo_get, so it can be stored in a
+// bytea column.
+//
+// Unlike large objects, bytea values are read and written in
+// full, and are limited to 1GB. They're better suited to small
+// files that don't need to be streamed or read partially.
+func (fsys *FS) ExportToBytea(name string) (b []byte, err error) {
+	id, err := uuid.Parse(name)
+	if err != nil {
+		err = fs.ErrNotExist
+		return
+	}
+
+	const q = `SELECT lo_get(oid) FROM pgfs_metadata WHERE id = $1`
+	err = fsys.conn.QueryRow(q, id).Scan(&b)
+	if err == sql.ErrNoRows {
+		err = fs.ErrNotExist
+	}
+	return
+}
+
+// CreateFromBytea creates a new file with the given name and data
+// in a single query using lo_from_bytea, and returns its info. It's
+// the fast path for content that is already in memory, such as a
+// value read from a bytea column.
+//
+// The size and the SHA-256 digest of data are computed by the client.
+// See [FS.Create] for more details on the other arguments.
+func (fsys *FS) CreateFromBytea(name, contentType string, sys Sys, data []byte) (FileInfo, error) {
+	if err := fsys.checkWrite("create", name); err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.Parse(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: err}
+	}
+
+	if contentType == "" {
+		contentType = detectContentType(data, true)
+	}
+	if strings.TrimSpace(contentType) == "" {
+		contentType = BinaryType
+	}
+	sum := sha256.Sum256(data)
+
+	// A nil slice would be sent as NULL.
+	if data == nil {
+		data = []byte{}
+	}
+
+	const q = `
+		INSERT INTO pgfs_metadata (
+			oid, id, sys,
+			content_size, content_type, content_sha256
+		)
+		SELECT
+			lo_from_bytea(0, $6), $1, $2,
+			$3, $4, $5
+		WHERE NOT EXISTS(SELECT 1 FROM pgfs_metadata WHERE id = $1)
+	`
+	result, err := fsys.conn.Exec(q, id, sys, len(data), contentType, sum[:], data)
+	if err != nil {
+		return nil, err
+	}
+	n, err := result.RowsAffected()
+	switch {
+	case err != nil:
+		return nil, err
+	case n == 0:
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+
+	info, err := fsys.primary().Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return info.(FileInfo), nil
+}
+
+// ImportLO creates a new file with the given name from the content
+// of serverPath on the file system of the database server, using
+// lo_import.
+//
+// The operation requires the role to be a superuser or a member
+// of pg_read_server_files. See [FS.RegisterBatch] for the limits
+// that apply to the size of the file.
+func (fsys *FS) ImportLO(name, serverPath, contentType string, sys Sys) (FileInfo, error) {
+	if err := fsys.checkWrite("import", name); err != nil {
+		return nil, err
+	}
+
+	// The name is checked first, so no large
+	// object is imported for nothing.
+	if _, err := uuid.Parse(name); err != nil {
+		return nil, &fs.PathError{Op: "import", Path: name, Err: err}
+	}
+	exists, err := fsys.Exists(name)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, &fs.PathError{Op: "import", Path: name, Err: fs.ErrExist}
+	}
+
+	var oid OID
+	if err := fsys.conn.QueryRow(`SELECT lo_import($1)`, serverPath).Scan(&oid); err != nil {
+		return nil, err
+	}
+	if err := fsys.Register(name, oid, contentType, sys); err != nil {
+		unlink(fsys.conn, oid)
+		return nil, err
+	}
+
+	info, err := fsys.primary().Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return info.(FileInfo), nil
+}
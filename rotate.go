@@ -0,0 +1,42 @@
+package pgfs
+
+import (
+	"io"
+	"io/fs"
+
+	"github.com/google/uuid"
+)
+
+// Rotate returns a writer for a new file with the given name,
+// which replaces the file named oldName once closed. The old
+// file is removed by Close, in the same transaction as the one
+// in which the new file is inserted, so references to it can
+// be updated atomically by the caller.
+//
+// If the new file can't be written, or is discarded, the old
+// file is left intact. See [FS.Create] for more details on the
+// other arguments.
+func (fsys *FS) Rotate(oldName, newName, contentType string, sys Sys) (io.WriteCloser, error) {
+	if err := fsys.checkWrite("rotate", oldName); err != nil {
+		return nil, err
+	}
+
+	oldID, err := uuid.Parse(oldName)
+	if err != nil {
+		return nil, &fs.PathError{Op: "rotate", Path: oldName, Err: fs.ErrNotExist}
+	}
+	ok, err := fsys.Exists(oldName)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, &fs.PathError{Op: "rotate", Path: oldName, Err: fs.ErrNotExist}
+	}
+
+	wc, err := fsys.Create(newName, contentType, sys)
+	if err != nil {
+		return nil, err
+	}
+	wc.(*writer).replaces = oldID
+	return wc, nil
+}
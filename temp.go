@@ -0,0 +1,62 @@
+package pgfs
+
+import (
+	"io/fs"
+
+	"github.com/google/uuid"
+)
+
+// TempFile is a file being written, whose name is only decided
+// once its content is complete. It must be either published
+// with [TempFile.Publish] or discarded with [TempFile.Discard].
+type TempFile struct {
+	w *writer
+}
+
+// CreateTemp returns a new [TempFile]. See [FS.Create] for
+// more details on the arguments.
+func (fsys *FS) CreateTemp(contentType string, sys Sys) (*TempFile, error) {
+	wc, err := fsys.Create(GenerateUUID(), contentType, sys)
+	if err != nil {
+		return nil, err
+	}
+	return &TempFile{w: wc.(*writer)}, nil
+}
+
+// Write implements [io.Writer].
+func (t *TempFile) Write(p []byte) (int, error) {
+	return t.w.Write(p)
+}
+
+// Publish closes the file and stores it under name.
+//
+// If a file with the same name already exists, [fs.ErrExist]
+// is returned and t remains open, so it can be published
+// under another name or discarded.
+func (t *TempFile) Publish(name string) error {
+	if t.w.closed {
+		return fs.ErrClosed
+	}
+
+	id, err := uuid.Parse(name)
+	if err != nil {
+		return &fs.PathError{Op: "publish", Path: name, Err: err}
+	}
+
+	const q = `SELECT EXISTS(SELECT 1 FROM pgfs_metadata WHERE id = $1)`
+	var exists bool
+	if err := t.w.fsys.conn.QueryRow(q, id).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return &fs.PathError{Op: "publish", Path: name, Err: fs.ErrExist}
+	}
+
+	t.w.id = id
+	return t.w.Close()
+}
+
+// Discard closes the file and deletes its content.
+func (t *TempFile) Discard() error {
+	return t.w.abort()
+}
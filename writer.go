@@ -1,28 +1,96 @@
 package pgfs
 
 import (
+	"context"
+	"database/sql"
 	"hash"
+	"hash/crc32"
 	"io/fs"
 	"math"
 	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 )
 
+// tagPool holds the buffers used by writers to store the
+// first 512 bytes of a file when its content type is unknown.
+var tagPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 512)
+		return &b
+	},
+}
+
+// getTag returns an empty buffer from tagPool. The pointer
+// is kept so putTag doesn't allocate a new one.
+func getTag() *[]byte {
+	b := tagPool.Get().(*[]byte)
+	*b = (*b)[:0]
+	return b
+}
+
+// putTag returns b to tagPool. It must no longer be
+// referenced by the caller.
+func putTag(b *[]byte) {
+	if cap(*b) != 512 {
+		return
+	}
+	*b = (*b)[:0]
+	tagPool.Put(b)
+}
+
+// FullDetectionLimit is the largest [CreateOptions.SizeHint] for
+// which the content type of a file is detected from its entire
+// content, rather than from its first 512 bytes.
+const FullDetectionLimit = 1 << 20
+
+// detectContentType is like [http.DetectContentType], but content
+// detected as plain text is checked in full when b is the entire
+// content of the file, so that binary bytes or invalid UTF-8
+// sequences beyond the first 512 bytes are not ignored.
+func detectContentType(b []byte, full bool) string {
+	contentType := http.DetectContentType(b)
+	if !full || len(b) <= 512 || !strings.HasPrefix(contentType, "text/plain") {
+		return contentType
+	}
+	if !utf8.Valid(b) {
+		return BinaryType
+	}
+	for _, c := range b {
+		// Same control bytes as [http.DetectContentType].
+		if c <= 0x08 || c == 0x0B || (0x0E <= c && c <= 0x1A) || (0x1C <= c && c <= 0x1F) {
+			return BinaryType
+		}
+	}
+	return contentType
+}
+
+// castagnoli is the table used to compute CRC-32C checksums.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
 // writer writes data in a large object,
 // and inserts a row in the metadata table
 // when closed.
 type writer struct {
+	ctx         context.Context
 	fd          int32
 	oid         OID
 	id          uuid.UUID
 	sys         Sys
+	meta        []byte
 	contentType string
 	size        int64
 	hasher      hash.Hash
+	crc         hash.Hash32 // nil unless requested
 	fsys        *FS
 	closed      bool
-	tag         []byte // holds the first 512 bytes
+	tx          *sql.Tx   // set by [NewDB], committed on Close
+	tag         []byte    // holds the first cap(tag) bytes
+	pooledTag   *[]byte   // backs tag when it comes from tagPool
+	replaces    uuid.UUID // set by [FS.Rotate], removed on Close
 }
 
 // Write implements [io.WriteCloser].
@@ -32,13 +100,21 @@ func (w *writer) Write(b []byte) (n int, err error) {
 		return
 	}
 
-	n, err = write(w.fsys.conn, w.fd, b)
+	if w.size+int64(len(b)) > maxSize {
+		err = ErrLargeObjectFull
+		return
+	}
+
+	n, err = write(w.ctx, w.fsys.conn, w.fd, b)
 	w.size += int64(n)
 	w.hasher.Write(b[:n])
+	if w.crc != nil {
+		w.crc.Write(b[:n])
+	}
 
-	// Store up to 512b for [http.DetectContentType].
+	// Store up to cap(w.tag) bytes for detectContentType.
 	if w.contentType == "" {
-		if m := 512 - len(w.tag); n > 0 && m > 0 {
+		if m := cap(w.tag) - len(w.tag); n > 0 && m > 0 {
 			i := int(math.Min(float64(n), float64(m)))
 			w.tag = append(w.tag, b[:i]...)
 		}
@@ -48,32 +124,108 @@ func (w *writer) Write(b []byte) (n int, err error) {
 }
 
 // Close implements [io.WriteCloser].
+//
+// The writer is closed even if an error is returned, in
+// which case the large object is deleted, or rolled back
+// with the transaction it was written in.
 func (w *writer) Close() error {
 	if w.closed {
 		return fs.ErrClosed
 	}
+	w.closed = true
 
 	if w.contentType == "" {
-		w.contentType = http.DetectContentType(w.tag)
+		w.contentType = detectContentType(w.tag, int64(len(w.tag)) == w.size)
+		w.releaseTag()
+	}
+	if strings.TrimSpace(w.contentType) == "" {
+		w.contentType = BinaryType
+	}
+
+	if w.crc != nil {
+		sys := make(Sys, len(w.sys)+1)
+		for k, v := range w.sys {
+			sys[k] = v
+		}
+		sys[CRC32CKey] = formatCRC32C(w.crc.Sum32())
+		w.sys = sys
 	}
 
 	const q = `
 	  INSERT INTO pgfs_metadata (
 			oid, id, sys,
-			content_size, content_type, content_sha256
+			content_size, content_type, content_sha256,
+			meta
 		) 
 		VALUES (
 			$1, $2, $3,
-			$4, $5, $6
+			$4, $5, $6,
+			$7
 		)
   `
-	if _, err := w.fsys.conn.Exec(q, w.oid, w.id, w.sys, w.size, w.contentType, w.hasher.Sum(nil)); err != nil {
-		return err
+	// w.ctx might be done already, and the
+	// descriptor must be released regardless.
+	if err := close(context.Background(), w.fsys.conn, w.fd); err != nil {
+		return w.fail(err)
 	}
-	if err := close(w.fsys.conn, w.fd); err != nil {
-		return err
+	if _, err := exec(w.ctx, w.fsys.conn, q, w.oid, w.id, w.sys, w.size, w.contentType, w.hasher.Sum(nil), w.meta); err != nil {
+		return w.fail(err)
 	}
+	if w.replaces != uuid.Nil {
+		if err := remove(w.ctx, w.fsys.conn, w.replaces); err != nil {
+			// Rolling back the transaction discards
+			// the new file, which is removed otherwise.
+			if w.tx == nil {
+				remove(context.Background(), w.fsys.conn, w.id)
+			}
+			return end(w.tx, &fs.PathError{Op: "rotate", Path: w.replaces.String(), Err: err})
+		}
+	}
+	return end(w.tx, nil)
+}
+
+// fail deletes the large object of a writer whose Close
+// failed, and returns err.
+//
+// Rolling back the transaction of a writer returned by
+// [NewDB] deletes the large object, and a caller-supplied
+// transaction is expected to be rolled back after a failed
+// query, so the deletion is only a best effort.
+func (w *writer) fail(err error) error {
+	if w.tx == nil {
+		unlink(w.fsys.conn, w.oid)
+	}
+	return end(w.tx, err)
+}
 
+// abort closes the file descriptor and deletes the large
+// object without inserting a metadata row.
+func (w *writer) abort() error {
+	if w.closed {
+		return fs.ErrClosed
+	}
 	w.closed = true
-	return nil
+	w.releaseTag()
+
+	// Rolling back the transaction deletes the
+	// large object and releases the descriptor.
+	if w.tx != nil {
+		return w.tx.Rollback()
+	}
+
+	// w.ctx might be done already, and the
+	// descriptor must be released regardless.
+	if err := close(context.Background(), w.fsys.conn, w.fd); err != nil {
+		return err
+	}
+	return unlink(w.fsys.conn, w.oid)
+}
+
+// releaseTag returns the tag buffer to the pool.
+func (w *writer) releaseTag() {
+	if w.pooledTag != nil {
+		putTag(w.pooledTag)
+		w.pooledTag = nil
+	}
+	w.tag = nil
 }